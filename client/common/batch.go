@@ -0,0 +1,96 @@
+package common
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// batchIDSize Bytes taken by the widest batch ID and round, each preceded
+// by its delimiter
+const batchIDSize = 2 * (1 + 20)
+
+// batchBuilder Groups the bets read from a bet source in batches of up to
+// maxAmount bets. Batches are also cut before their serialization exceeds
+// the size of a single frame
+type batchBuilder struct {
+	reader    BetSource
+	maxAmount int
+	// pending Bet that did not fit in the previous batch
+	pending []byte
+	bet     codec.BetMessage
+	// invalid Called with the line and the error of every row skipped
+	invalid func(line int, err error)
+}
+
+func newBatchBuilder(reader BetSource, maxAmount int, invalid func(line int, err error)) *batchBuilder {
+	if maxAmount < 1 {
+		maxAmount = 1
+	}
+	return &batchBuilder{
+		reader:    reader,
+		maxAmount: maxAmount,
+		invalid:   invalid,
+	}
+}
+
+// next Returns the following batch of bets or io.EOF once the file was
+// completely read. Rows that are not valid bets are skipped
+func (b *batchBuilder) next() (codec.BetBatchMessage, error) {
+	var batch codec.BetBatchMessage
+	size := len(batch.Type()) + batchIDSize
+
+	for len(batch.Bets) < b.maxAmount {
+		if b.pending == nil {
+			if err := b.readBet(); err != nil {
+				if err == io.EOF && len(batch.Bets) > 0 {
+					break
+				}
+				return batch, err
+			}
+		}
+
+		// Every bet is preceded by the record delimiter
+		betSize := 1 + len(b.pending)
+		if len(batch.Bets) > 0 && size+betSize > framing.MaxPayloadSize {
+			break
+		}
+		batch.Bets = append(batch.Bets, b.bet)
+		size += betSize
+		b.pending = nil
+	}
+	return batch, nil
+}
+
+// readBet Reads bets until a valid one is found and keeps it as pending
+func (b *batchBuilder) readBet() error {
+	for {
+		bet, err := b.reader.Read()
+		if _, malformed := err.(*csv.ParseError); err != nil && !malformed {
+			return err
+		}
+		if err == nil {
+			msg := bet.toMessage()
+			encoded, encodeErr := codec.Encode(msg)
+			if encodeErr == nil {
+				b.bet, b.pending = msg, encoded
+				return nil
+			}
+			err = encodeErr
+		}
+		if b.invalid != nil {
+			b.invalid(b.line(), err)
+		}
+	}
+}
+
+// line Line where the last bet read starts, or 0 when the source does not
+// come from a file
+func (b *batchBuilder) line() int {
+	if lines, ok := b.reader.(interface{ Line() int }); ok {
+		return lines.Line()
+	}
+	return 0
+}
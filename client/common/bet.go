@@ -0,0 +1,97 @@
+package common
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+)
+
+// betFileFields Amount of fields of every row of an agency file: first name,
+// last name, document, birthdate and number
+const betFileFields = 5
+
+// Bet A lottery bet placed by a person in an agency
+type Bet struct {
+	Agency    string
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    string
+}
+
+// toMessage Builds the protocol message used to send the bet to the server
+func (b Bet) toMessage() codec.BetMessage {
+	return codec.BetMessage{
+		Agency:    b.Agency,
+		FirstName: b.FirstName,
+		LastName:  b.LastName,
+		Document:  b.Document,
+		Birthdate: b.Birthdate,
+		Number:    b.Number,
+	}
+}
+
+// BetReader Reads the bets of an agency from its CSV file, one bet per row
+type BetReader struct {
+	agency string
+	file   *os.File
+	reader *csv.Reader
+	line   int
+}
+
+// NewBetReader Opens the CSV file holding the bets placed in the agency
+func NewBetReader(path string, agency string) (*BetReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = betFileFields
+	return &BetReader{
+		agency: agency,
+		file:   file,
+		reader: reader,
+	}, nil
+}
+
+// Read Returns the next bet of the file. io.EOF is returned once every
+// row was read. Rows that cannot be parsed are reported with their line
+// but do not prevent reading the following ones
+func (r *BetReader) Read() (Bet, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return Bet{}, err
+	}
+	if record != nil {
+		r.line, _ = r.reader.FieldPos(0)
+	}
+	if err != nil {
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			r.line = parseErr.Line
+		}
+		return Bet{}, err
+	}
+
+	return Bet{
+		Agency:    r.agency,
+		FirstName: record[0],
+		LastName:  record[1],
+		Document:  record[2],
+		Birthdate: record[3],
+		Number:    record[4],
+	}, nil
+}
+
+// Line Line of the file where the last read row starts
+func (r *BetReader) Line() int {
+	return r.line
+}
+
+// Close Closes the underlying file
+func (r *BetReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,162 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BreakerState State of the circuit breaker guarding the server connection
+type BreakerState int32
+
+const (
+	// BreakerClosed Connections are attempted normally
+	BreakerClosed BreakerState = iota
+	// BreakerOpen Connections are refused until the cool-down elapses
+	BreakerOpen
+	// BreakerHalfOpen The cool-down elapsed and a connection is attempted
+	// to probe whether the server recovered
+	BreakerHalfOpen
+)
+
+var breakerStates = []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen}
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(s))
+}
+
+// ErrCircuitOpen Returned instead of connecting while the circuit breaker
+// is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker Stops connecting to the server after threshold
+// consecutive failures. Once the cool-down elapses a single probe is let
+// through: the circuit closes if it succeeds and opens again otherwise.
+// Other connections are refused while the probe is in flight, unless it
+// did not report back within another cool-down. A nil breaker lets
+// everything through. Every method is safe for concurrent
+// use
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	onChange  func(from BreakerState, to BreakerState)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// probedAt When the probe of the half-open breaker was let through
+	probedAt time.Time
+}
+
+// newCircuitBreaker Returns a closed breaker, or nil when threshold is not
+// positive. onChange is called on every transition
+func newCircuitBreaker(threshold int, coolDown time.Duration, onChange func(from BreakerState, to BreakerState)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+// allow Returns ErrCircuitOpen while the breaker is open or its probe is
+// in flight. The first call after the cool-down moves the breaker to
+// half-open and becomes the probe
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.coolDown {
+			return ErrCircuitOpen
+		}
+		b.transition(BreakerHalfOpen)
+		b.probedAt = now
+	case BreakerHalfOpen:
+		if now.Sub(b.probedAt) < b.coolDown {
+			return ErrCircuitOpen
+		}
+		b.probedAt = now
+	}
+	return nil
+}
+
+// success Records a reply from the server, which closes the breaker
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != BreakerClosed {
+		b.transition(BreakerClosed)
+	}
+}
+
+// failure Records a failed connection. The breaker opens once the
+// threshold is reached or right away if the failure was a probe
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		b.transition(BreakerOpen)
+	}
+}
+
+// retryIn Time left until the breaker lets a probe through, or another one
+// when the probe in flight does not report back
+func (b *circuitBreaker) retryIn() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	since := b.openedAt
+	switch b.state {
+	case BreakerClosed:
+		return 0
+	case BreakerHalfOpen:
+		since = b.probedAt
+	}
+	if left := b.coolDown - b.now().Sub(since); left > 0 {
+		return left
+	}
+	return 0
+}
+
+func (b *circuitBreaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	if b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
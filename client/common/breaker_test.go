@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/faultproxy"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	var transitions []BreakerState
+	breaker := newCircuitBreaker(2, time.Second, func(from BreakerState, to BreakerState) {
+		transitions = append(transitions, to)
+	})
+	breaker.now = func() time.Time { return now }
+
+	breaker.failure()
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() below the threshold = %v", err)
+	}
+	breaker.failure()
+	if err := breaker.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() after reaching the threshold = %v, want %v", err, ErrCircuitOpen)
+	}
+	if retryIn := breaker.retryIn(); retryIn != time.Second {
+		t.Errorf("retryIn() = %v, want 1s", retryIn)
+	}
+
+	// A failed probe opens the breaker right away
+	now = now.Add(time.Second)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() after the cool-down = %v", err)
+	}
+	breaker.failure()
+	if err := breaker.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() after a failed probe = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// Only the probe is let through until it reports back
+	now = now.Add(time.Second)
+	breaker.allow()
+	if err := breaker.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() while the probe is in flight = %v, want %v", err, ErrCircuitOpen)
+	}
+	if retryIn := breaker.retryIn(); retryIn != time.Second {
+		t.Errorf("retryIn() while the probe is in flight = %v, want 1s", retryIn)
+	}
+	// A probe that never reports back is replaced after another cool-down
+	now = now.Add(time.Second)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() once the probe timed out = %v", err)
+	}
+	breaker.success()
+	breaker.failure()
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() after recovering = %v", err)
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestClientWaitsForCircuitBreakerCoolDown(t *testing.T) {
+	// Every connection is closed right away because the server behind the
+	// proxy is gone
+	server := startServer(t, 1)
+	unreachable := server.Addr
+	server.Close()
+	proxy := startFaultProxy(t, unreachable, faultproxy.Fault{})
+
+	config := testConfig(server, manyBetsFile(t, 3))
+	config.ServerAddress = proxy.Addr
+	config.BatchMaxRetries = 3
+	config.BreakerFailures = 1
+	config.BreakerCoolDown = 30 * time.Millisecond
+	client := NewClient(config)
+
+	start := time.Now()
+	if err := client.StartClientLoop(context.Background()); err == nil {
+		t.Fatal("StartClientLoop() succeeded without a server")
+	}
+	if elapsed := time.Since(start); elapsed < 3*config.BreakerCoolDown {
+		t.Errorf("client gave up after %v, want at least three cool-downs", elapsed)
+	}
+
+	snapshot := client.Metrics().Snapshot()
+	if snapshot.BreakerState != BreakerOpen || snapshot.BreakerOpens != 4 {
+		t.Errorf("breaker state = %v after opening %d times, want open after 4", snapshot.BreakerState, snapshot.BreakerOpens)
+	}
+}
+
+func TestClientCountsEachFailedDialOnce(t *testing.T) {
+	server := startServer(t, 1)
+	server.Close()
+
+	config := testConfig(server, manyBetsFile(t, 3))
+	config.BreakerFailures = 2
+	config.BreakerCoolDown = time.Hour
+	client := NewClient(config)
+
+	// A single dial is attempted, which must not reach the two failures
+	// that open the breaker
+	if err := client.StartClientLoop(context.Background()); err == nil {
+		t.Fatal("StartClientLoop() succeeded without a server")
+	}
+	if snapshot := client.Metrics().Snapshot(); snapshot.BreakerOpens != 0 {
+		t.Errorf("breaker opened %d times after a single failed dial", snapshot.BreakerOpens)
+	}
+}
@@ -0,0 +1,231 @@
+// Package capture stores the frames exchanged with the server so a session
+// can be inspected or replayed later.
+//
+// A capture file starts with a fixed header followed by one record per
+// frame:
+//
+//	[MAGIC][VERSION]
+//	[DIRECTION][CONNECTION][TIMESTAMP][LEN_BYTES][PAYLOAD]
+//	...
+//
+// The connection is a sequence number starting at 1 that tells apart the
+// connections opened during the session, and the timestamp holds the
+// nanoseconds since the Unix epoch. Payloads are stored as frames, exactly
+// as they are written on the wire.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// Direction Tells whether a frame was sent or received by the client
+type Direction byte
+
+const (
+	// Sent Frame written by the client
+	Sent Direction = '>'
+	// Received Frame read by the client
+	Received Direction = '<'
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "sent"
+	case Received:
+		return "received"
+	}
+	return "unknown"
+}
+
+// Magic Bytes every capture file starts with
+const Magic = "LOTCAP"
+
+const (
+	version = 1
+
+	// recordHeaderSize Size of the direction, connection and timestamp
+	// stored before every frame
+	recordHeaderSize = 1 + 4 + 8
+)
+
+// ErrNotCapture Returned when a file does not start with a capture header
+var ErrNotCapture = errors.New("not a capture file")
+
+// Record Frame exchanged with the server
+type Record struct {
+	Direction  Direction
+	Connection uint32
+	Time       time.Time
+	Payload    []byte
+}
+
+// Writer Appends records to a capture. It is safe for concurrent use, so
+// the frames read and written by different goroutines can be recorded on
+// the same capture. The first error is kept and every later record is
+// dropped, so a failing capture never interrupts the session
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	now    func() time.Time
+	closer io.Closer
+	err    error
+}
+
+// NewWriter Writes the capture header to w and returns a writer for the
+// records, which are timestamped by now. Records are buffered until Flush
+// or Close are called
+func NewWriter(w io.Writer, now func() time.Time) (*Writer, error) {
+	writer := &Writer{w: bufio.NewWriter(w), now: now}
+	writer.w.WriteString(Magic)
+	writer.w.WriteByte(version)
+	if err := writer.w.Flush(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Create Creates the capture file at path, truncating it if it exists.
+// Records are timestamped by now
+func Create(path string, now func() time.Time) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewWriter(file, now)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer.closer = file
+	return writer, nil
+}
+
+// Record Appends a frame of the given connection timestamped with the
+// time of the writer
+func (w *Writer) Record(direction Direction, connection uint32, payload []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+
+	var header [recordHeaderSize]byte
+	header[0] = byte(direction)
+	binary.BigEndian.PutUint32(header[1:5], connection)
+	binary.BigEndian.PutUint64(header[5:], uint64(w.now().UnixNano()))
+	if _, err := w.w.Write(header[:]); err != nil {
+		w.err = err
+		return
+	}
+	w.err = framing.WriteFrame(w.w, payload)
+}
+
+// Flush Writes the buffered records to the underlying writer
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// Close Flushes the buffered records and closes the capture file. Returns
+// the first error found while recording, if any
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Reader Reads the records of a capture
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader Checks the capture header of r and returns a reader for its
+// records
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r)}
+	header := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotCapture
+		}
+		return nil, err
+	}
+	if string(header[:len(Magic)]) != Magic {
+		return nil, ErrNotCapture
+	}
+	if header[len(Magic)] != version {
+		return nil, errors.Errorf("unsupported capture version %d", header[len(Magic)])
+	}
+	return reader, nil
+}
+
+// Next Returns the next record of the capture or io.EOF once there are no
+// records left. A capture truncated in the middle of a record returns
+// io.ErrUnexpectedEOF
+func (r *Reader) Next() (Record, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return Record{}, err
+	}
+
+	direction := Direction(header[0])
+	if direction != Sent && direction != Received {
+		return Record{}, errors.Errorf("invalid record direction %q", header[0])
+	}
+	payload, err := framing.ReadFrame(r.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Direction:  direction,
+		Connection: binary.BigEndian.Uint32(header[1:5]),
+		Time:       time.Unix(0, int64(binary.BigEndian.Uint64(header[5:]))),
+		Payload:    payload,
+	}, nil
+}
+
+// ReadFile Returns every record of the capture file at path
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,63 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriterAndReaderRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.lcap")
+	start := time.Date(2024, 3, 17, 10, 0, 0, 0, time.UTC)
+	now := start
+	writer, err := Create(path, func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Record(Sent, 1, []byte("BetBatchMessage|1;BetMessage|1|Nicolás|Peña|27726965|1994-03-16|7574"))
+	writer.Record(Received, 1, []byte("AckMessage|1|0"))
+	writer.Record(Sent, 2, []byte("WinnersRequestMessage|1"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("read %d records, want 3", len(records))
+	}
+	if r := records[1]; r.Direction != Received || r.Connection != 1 || string(r.Payload) != "AckMessage|1|0" {
+		t.Errorf("second record = %+v", r)
+	}
+	// Records are timestamped by the clock of the writer
+	if r := records[2]; r.Connection != 2 || !r.Time.Equal(start.Add(3*time.Second)) {
+		t.Errorf("third record = %+v", r)
+	}
+}
+
+func TestReaderRejectsInvalidCaptures(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("AckMessage|1|0"))); err != ErrNotCapture {
+		t.Errorf("NewReader() on a non capture = %v, want %v", err, ErrNotCapture)
+	}
+
+	var buf bytes.Buffer
+	writer, _ := NewWriter(&buf, time.Now)
+	writer.Record(Sent, 1, []byte("PingMessage"))
+	writer.Flush()
+	truncated := buf.Bytes()[:buf.Len()-2]
+
+	reader, err := NewReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() on a truncated record = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -1,89 +1,424 @@
 package common
 
 import (
-	"bufio"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"io"
 	"net"
 	"time"
 
-	"github.com/op/go-logging"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
 )
 
-var log = logging.MustGetLogger("log")
-
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
 	ID            string
 	ServerAddress string
-	LoopAmount    int
-	LoopPeriod    time.Duration
+	// ServerAddresses Server endpoints picked by EndpointPolicy. When empty
+	// ServerAddress is the only endpoint
+	ServerAddresses []string
+	EndpointPolicy  EndpointPolicy
+	// Resolver Looks up the endpoints, net.DefaultResolver when nil. The
+	// addresses found are cached for ResolveTTL, or DefaultResolveTTL when
+	// it is not positive
+	Resolver   Resolver
+	ResolveTTL time.Duration
+	// TLSConfig Used by tls:// endpoints. The system roots are trusted
+	// when nil
+	TLSConfig *tls.Config
+
+	LoopPeriod      time.Duration
+	BatchMaxAmount  int
+	BetsFile        string
+	MetricsAddress  string
+	ProgressPeriod  time.Duration
+	BatchWindow     int
+	BatchMaxRetries int
+	BetsPerSecond   float64
+	BetsBurst       int
+	BytesPerSecond  float64
+	BytesBurst      int
+	BreakerFailures int
+	BreakerCoolDown time.Duration
+	WinnersOutput   string
+	WinnersFormat   string
+	CaptureDir      string
+	// DrawTimeout Time the connection the bets were sent through is kept
+	// open after notifying the end of the bets, waiting for the server to
+	// push the draw. The client polls for the winners once it expires, or
+	// right away when it is not positive
+	DrawTimeout time.Duration
+	// Round Draw the bets are placed in and the winners are asked for.
+	// codec.DefaultRound is used by servers that host a single draw
+	Round       uint64
+	PingTimeout time.Duration
+	WaitTimeout time.Duration
 }
 
-// Client Entity that encapsulates how
+// Client Entity that encapsulates how the agency communicates with the
+// server: it uploads the bets of its file and then asks for its winners
 type Client struct {
-	config ClientConfig
-	conn   net.Conn
+	config   ClientConfig
+	conn     net.Conn
+	metrics  *Metrics
+	progress *progressReporter
+	breaker  *circuitBreaker
+
+	// Collaborators replaceable through the options of NewClient. A nil
+	// source reads the bets file and a nil results exports the winners
+	// to the configured output, if any
+	log     Logger
+	dialer  Dialer
+	clock   clock.Clock
+	source  BetSource
+	results ResultSink
+
+	// endpoints Servers the client may connect to. endpoint is the address
+	// of the last connection opened. A wrong endpoint configuration is
+	// kept in configErr and returned when the loop starts
+	endpoints  *endpointSet
+	addresses  *addressCache
+	transports map[string]Transport
+	endpoint   string
+	configErr  error
+
+	// capture Records the frames of the session when a capture directory
+	// is configured. connID identifies the connection currently open
+	capture *capture.Writer
+	connID  uint32
+
+	// Session opened by Connect. upload keeps the connection the bets are
+	// sent through until the server is notified they are done
+	upload        *pipeline
+	metricsServer io.Closer
+	stopProgress  func()
+	notified      bool
+	closed        bool
+	// commitment Hash of the seed of the draw the server committed to when
+	// the agency first registered. Empty when the server uses a fixed
+	// number. Later registrations must answer the same one
+	commitment string
+	registered bool
+	// committedBets Documents of the bets the server acknowledged by
+	// number, kept when the server committed to the draw so the winners
+	// it answers can be checked against the number it reveals
+	committedBets map[string][]string
 }
 
 // NewClient Initializes a new client receiving the configuration
-// as a parameter
-func NewClient(config ClientConfig) *Client {
+// as a parameter. Options are applied before building the collaborators
+// that depend on them
+func NewClient(config ClientConfig, options ...Option) *Client {
 	client := &Client{
-		config: config,
+		config:     config,
+		log:        defaultLogger,
+		dialer:     &net.Dialer{},
+		clock:      clock.System,
+		transports: make(map[string]Transport),
+	}
+	for _, option := range options {
+		option(client)
+	}
+	if client.metrics == nil {
+		client.metrics = NewMetrics()
+	}
+	if client.results == nil && config.WinnersOutput != "" {
+		client.results = &winnersFile{
+			path:     config.WinnersOutput,
+			format:   config.WinnersFormat,
+			betsFile: config.BetsFile,
+			agency:   config.ID,
+		}
+	}
+	client.breaker = newCircuitBreaker(config.BreakerFailures, config.BreakerCoolDown, client.breakerChanged)
+	if client.breaker != nil {
+		client.breaker.now = client.clock.Now
+	}
+
+	addresses := config.ServerAddresses
+	if len(addresses) == 0 && config.ServerAddress != "" {
+		addresses = []string{config.ServerAddress}
+	}
+	client.endpoints, client.configErr = newEndpointSet(addresses, config.EndpointPolicy, client.endpointChanged)
+	if client.endpoints != nil {
+		client.endpoints.now = client.clock.Now
+	}
+	ttl := config.ResolveTTL
+	if ttl <= 0 {
+		ttl = DefaultResolveTTL
+	}
+	client.addresses = newAddressCache(config.Resolver, ttl)
+	client.addresses.log = client.log
+	client.addresses.now = client.clock.Now
+	for scheme, transport := range newTransports(client.addresses, client.dialer, config.TLSConfig) {
+		if _, ok := client.transports[scheme]; !ok {
+			client.transports[scheme] = transport
+		}
+	}
+	for _, address := range addresses {
+		client.metrics.SetEndpointHealth(address, true)
 	}
 	return client
 }
 
+// Metrics Returns the metrics updated by the client
+func (c *Client) Metrics() *Metrics {
+	return c.metrics
+}
+
 // CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+// failure, error is printed in stdout/stderr and returned
+func (c *Client) createClientSocket(ctx context.Context) error {
+	if err := c.breaker.allow(); err != nil {
+		c.log.Errorf("action: connect | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	c.metrics.SetConnectionState(Connecting)
+
+	c.endpoint = c.endpoints.pick()
+	conn, err := dialEndpoint(ctx, c.transports, c.endpoint)
 	if err != nil {
-		log.Criticalf(
-			"action: connect | result: fail | client_id: %v | error: %v",
+		c.metrics.SetConnectionState(Disconnected)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.connectionFailed()
+		c.log.Criticalf(
+			"action: connect | result: fail | client_id: %v | server_address: %v | error: %v",
 			c.config.ID,
+			c.endpoint,
 			err,
 		)
+		return err
 	}
-	c.conn = conn
+
+	c.endpoints.success(c.endpoint)
+	c.conn = &meteredConn{Conn: conn, metrics: c.metrics}
+	c.connID++
+	c.metrics.SetConnectionState(Connected)
 	return nil
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
-	// There is an autoincremental msgID to identify every message sent
-	// Messages if the message amount threshold has not been surpassed
-	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
+// closeClientSocket Closes the connection to the server if there is one
+func (c *Client) closeClientSocket() {
+	if c.conn == nil {
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+	c.metrics.SetConnectionState(Disconnected)
+}
+
+// send Serializes the message and writes it as a single frame
+func (c *Client) send(msg codec.Message) error {
+	payload, err := codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if c.capture != nil {
+		c.capture.Record(capture.Sent, c.connID, payload)
+	}
+	return framing.WriteFrame(c.conn, payload)
+}
+
+// receive Reads the next message sent by the server
+func (c *Client) receive() (codec.Message, error) {
+	return c.receiveFrom(c.conn, c.connID)
+}
+
+// receiveFrom Reads the next message sent by the server through the given
+// connection. Used by the goroutines that keep reading a connection while
+// the client may be replacing it
+func (c *Client) receiveFrom(conn io.Reader, connID uint32) (codec.Message, error) {
+	payload, err := framing.ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	c.breaker.success()
+	if c.capture != nil {
+		c.capture.Record(capture.Received, connID, payload)
+	}
+	return codec.Decode(payload)
+}
+
+// connectionFailed Records a failed connection to the current endpoint
+func (c *Client) connectionFailed() {
+	c.breaker.failure()
+	c.endpoints.failure(c.endpoint)
+	// The server may have moved, so the endpoint is resolved again
+	_, address := splitEndpoint(c.endpoint)
+	c.addresses.invalidate(address)
+}
 
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
+// endpointChanged Logs every change in the health of an endpoint and
+// exposes it in the metrics
+func (c *Client) endpointChanged(address string, healthy bool) {
+	c.metrics.SetEndpointHealth(address, healthy)
+	if healthy {
+		c.log.Infof("action: endpoint_health | result: success | client_id: %v | server_address: %v | healthy: true",
 			c.config.ID,
-			msgID,
+			address,
 		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		c.conn.Close()
+		return
+	}
+	c.log.Warningf("action: endpoint_health | result: fail | client_id: %v | server_address: %v | healthy: false",
+		c.config.ID,
+		address,
+	)
+}
+
+// breakerChanged Logs every transition of the circuit breaker and exposes
+// the new state in the metrics
+func (c *Client) breakerChanged(from BreakerState, to BreakerState) {
+	c.metrics.SetBreakerState(to)
+	if to == BreakerOpen {
+		c.log.Warningf("action: circuit_breaker | result: fail | client_id: %v | from: %v | to: %v | cool_down: %v",
+			c.config.ID,
+			from,
+			to,
+			c.config.BreakerCoolDown,
+		)
+		return
+	}
+	c.log.Infof("action: circuit_breaker | result: success | client_id: %v | from: %v | to: %v",
+		c.config.ID,
+		from,
+		to,
+	)
+}
+
+// StartClientLoop Sends the bets of the agency in batches, notifies the
+// server once all of them were sent and then waits for the winners of the
+// agency. The loop stops as soon as ctx is cancelled
+func (c *Client) StartClientLoop(ctx context.Context) error {
+	defer c.Close()
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+
+	// Rejected batches were already logged and do not stop the agency
+	var err error
+	if c.source != nil {
+		err = c.SendBets(ctx, c.source)
+	} else {
+		err = c.SendBetsFile(ctx, c.config.BetsFile)
+	}
+	if _, rejected := err.(*RejectedError); err != nil && !rejected {
+		return err
+	}
+
+	if err := c.NotifyDone(ctx); err != nil {
+		return err
+	}
+	if _, err := c.QueryWinners(ctx); err != nil {
+		return err
+	}
 
+	c.log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+	return nil
+}
+
+// queryWinners Asks for the winners of the agency in the round until the
+// server answers with them. The server replies that winners are pending
+// while there are agencies still sending their bets
+func (c *Client) queryWinners(ctx context.Context, round uint64) ([]string, error) {
+	for {
+		msg, err := c.request(ctx, codec.WinnersRequestMessage{Agency: c.config.ID, Round: round})
 		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v",
 				c.config.ID,
 				err,
 			)
-			return
+			return nil, err
 		}
 
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
-			c.config.ID,
-			msg,
-		)
+		switch reply := msg.(type) {
+		case codec.WinnersNotificationMessage:
+			return reply.Documents, nil
+		case codec.WinnersPendingMessage:
+			c.log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v", c.config.ID)
+			if err := c.sleep(ctx, c.config.LoopPeriod); err != nil {
+				return nil, err
+			}
+		case codec.DrawMissedMessage:
+			return nil, ErrDrawMissed
+		case codec.UnknownRoundMessage:
+			c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | round: %v | error: %v",
+				c.config.ID,
+				round,
+				ErrUnknownRound,
+			)
+			return nil, ErrUnknownRound
+		default:
+			err := &UnexpectedMessageError{Got: msg.Type(), Want: codec.TypeWinnersNotification}
+			c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v",
+				c.config.ID,
+				err,
+			)
+			return nil, err
+		}
+	}
+}
+
+// request Sends a single request and returns its reply. A new connection
+// is used for every request so the server can attend other agencies
+// meanwhile
+func (c *Client) request(ctx context.Context, msg codec.Message) (codec.Message, error) {
+	if err := c.createClientSocket(ctx); err != nil {
+		return nil, err
+	}
+	defer c.closeClientSocket()
+	defer closeOnCancel(ctx, c.conn)()
+
+	start := c.clock.Now()
+	if err := c.send(msg); err != nil {
+		c.connectionFailed()
+		return nil, err
+	}
+	reply, err := c.receive()
+	if err != nil {
+		c.connectionFailed()
+		return nil, err
+	}
+	c.metrics.ObserveRTT(c.clock.Now().Sub(start))
+	return reply, nil
+}
+
+// closeOnCancel Closes conn as soon as ctx is cancelled so blocking reads
+// and writes return. The returned function stops watching ctx, and conn is
+// not closed once it returns
+func closeOnCancel(ctx context.Context, conn io.Closer) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
 
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+// sleep Waits for the given duration unless ctx is cancelled first
+func (c *Client) sleep(ctx context.Context, d time.Duration) error {
+	timer := c.clock.NewTimer(d)
+	defer timer.Stop()
 
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
 	}
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
 }
@@ -0,0 +1,215 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+// writeBetsFile Writes the rows to an agency file in a temporary directory
+func writeBetsFile(t *testing.T, rows ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(rows, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startServer Starts a fake server that is closed when the test finishes
+func startServer(t *testing.T, agencies int) *lotterytest.Server {
+	t.Helper()
+	server, err := lotterytest.NewServer(agencies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func testConfig(server *lotterytest.Server, betsFile string) ClientConfig {
+	return ClientConfig{
+		ID:             "1",
+		ServerAddress:  server.Addr,
+		LoopPeriod:     time.Millisecond,
+		BatchMaxAmount: 2,
+		BetsFile:       betsFile,
+	}
+}
+
+func TestClientLoopUploadsBetsAndQueriesWinners(t *testing.T) {
+	server := startServer(t, 1)
+	betsFile := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,1987-08-01,8676",
+		"Nicolás,Peña,27726965,1994-03-16,7574",
+		"not,a,valid,bet,row",
+		"Martina Pilar,Mamani,29369913,1989-12-05,6857",
+	)
+
+	client := NewClient(testConfig(server, betsFile))
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+
+	bets := server.Bets()
+	if len(bets) != 4 {
+		t.Fatalf("server stored %d bets, want 4", len(bets))
+	}
+	if bets[2].FirstName != "Nicolás" || bets[2].Agency != "1" {
+		t.Errorf("third bet = %+v", bets[2])
+	}
+}
+
+func TestClientLoopStopsWhenContextIsCancelled(t *testing.T) {
+	// The draw never happens because a second agency is expected
+	server := startServer(t, 2)
+	betsFile := writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	client := NewClient(testConfig(server, betsFile))
+	if err := client.StartClientLoop(ctx); err != context.Canceled {
+		t.Fatalf("StartClientLoop() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClientLoopIsNotifiedOfTheDraw(t *testing.T) {
+	for _, push := range []bool{true, false} {
+		server := startServer(t, 2)
+		server.PushDraw(push)
+
+		errs := make(chan error, 2)
+		for _, id := range []string{"1", "2"} {
+			config := testConfig(server, writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574"))
+			config.ID = id
+			config.LoopPeriod = time.Hour
+			config.DrawTimeout = 500 * time.Millisecond
+			if push {
+				config.DrawTimeout = time.Hour
+			}
+			client := NewClient(config)
+			go func() { errs <- client.StartClientLoop(context.Background()) }()
+		}
+
+		// The hour long loop period leaves no time to poll: the agencies
+		// either get the draw pushed or ask for the winners once, after
+		// their wait is over and both of them are done
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-errs:
+				if err != nil {
+					t.Fatalf("push %v: StartClientLoop() = %v", push, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("push %v: clients did not learn about the draw", push)
+			}
+		}
+	}
+}
+
+func TestClientLoopTakesPartInSuccessiveRounds(t *testing.T) {
+	server := startServer(t, 1)
+	rounds := map[uint64]string{
+		1: writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574", "Maria Antonella,Leiva,24260718,1987-08-01,8676"),
+		2: writeBetsFile(t, "Nicolás,Peña,27726965,1994-03-16,7574"),
+	}
+	for round := uint64(1); round <= 2; round++ {
+		config := testConfig(server, rounds[round])
+		config.Round = round
+		if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+			t.Fatalf("round %d: StartClientLoop() = %v", round, err)
+		}
+	}
+	if got := len(server.RoundBets(1)); got != 2 {
+		t.Errorf("round 1 holds %d bets, want 2", got)
+	}
+
+	// The winners of every round stay available once the next one is drawn
+	client := NewClient(testConfig(server, ""))
+	defer client.Close()
+	want := map[uint64][]string{1: {"30904465"}, 2: {"27726965"}}
+	for round, documents := range want {
+		winners, err := client.QueryRoundWinners(context.Background(), round)
+		if err != nil || !reflect.DeepEqual(winners, documents) {
+			t.Errorf("QueryRoundWinners(%d) = %v, %v, want %v", round, winners, err, documents)
+		}
+	}
+	// A round yet to start is refused instead of waited for
+	if _, err := client.QueryRoundWinners(context.Background(), 3); err != ErrUnknownRound {
+		t.Errorf("QueryRoundWinners(3) = %v, want %v", err, ErrUnknownRound)
+	}
+}
+
+func TestClientLoopVerifiesTheCommittedDraw(t *testing.T) {
+	server, err := lotterytest.NewServerConfig(lotterytest.Config{Agencies: 1, CommitReveal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	number, err := server.WinningNumber(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	betsFile := writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,"+number)
+
+	logger := &recordingLogger{}
+	var winners []string
+	sink := sinkFunc(func(ctx context.Context, documents []string) error {
+		winners = documents
+		return nil
+	})
+	client := NewClient(testConfig(server, betsFile), WithLogger(logger), WithResultSink(sink))
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	if !reflect.DeepEqual(winners, []string{"30904465"}) {
+		t.Errorf("winners = %v, want [30904465]", winners)
+	}
+	if !logger.contains("verificar_sorteo") {
+		t.Error("the verification of the draw was not logged")
+	}
+}
+
+func TestClientLoopTakesPartInTheDrawOnlyBeforeTheDeadline(t *testing.T) {
+	server, err := lotterytest.NewServerConfig(lotterytest.Config{
+		Roster:       []string{"1", "2"},
+		DrawDeadline: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	betsFile := writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574")
+
+	// Agency 2 never shows up, so the draw waits for the deadline
+	config := testConfig(server, betsFile)
+	config.DrawTimeout = 5 * time.Second
+	if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	if got := server.Registered(0); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("Registered() = %v, want [1]", got)
+	}
+
+	logger := &recordingLogger{}
+	config.ID = "2"
+	if err := NewClient(config, WithLogger(logger)).StartClientLoop(context.Background()); err != ErrDrawMissed {
+		t.Errorf("late agency: StartClientLoop() = %v, want %v", err, ErrDrawMissed)
+	}
+	if !logger.contains("sorteo") {
+		t.Error("the missed draw was not logged")
+	}
+
+	config.ID = "3"
+	if err := NewClient(config).StartClientLoop(context.Background()); err != ErrNotInRoster {
+		t.Errorf("unknown agency: StartClientLoop() = %v, want %v", err, ErrNotInRoster)
+	}
+}
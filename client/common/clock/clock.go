@@ -0,0 +1,186 @@
+// Package clock abstracts reading the time and waiting, so the time-based
+// behavior of the client can be driven by tests without sleeping.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock Tells the time and lets the caller wait
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer Single event delivered through C once its duration elapses
+type Timer interface {
+	C() <-chan time.Time
+	// Stop Prevents the timer from firing. Returns false if it already
+	// fired or was stopped
+	Stop() bool
+	// Reset Makes the timer fire after d. Returns false if it already
+	// fired or was stopped
+	Reset(d time.Duration) bool
+}
+
+// System Clock backed by the time package
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time        { return t.timer.C }
+func (t systemTimer) Stop() bool                 { return t.timer.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.timer.Reset(d) }
+
+// Fake Clock whose time only moves when Advance is called. Timers fire,
+// and sleepers wake up, as soon as the time reaches their deadline. Every
+// method is safe for concurrent use
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFake Returns a fake clock set at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.NewTimer(d).C()
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance Moves the time forward by d firing, in deadline order, every
+// timer that expires meanwhile
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].deadline.Before(f.timers[j].deadline)
+	})
+	for len(f.timers) > 0 && !f.timers[0].deadline.After(f.now) {
+		t := f.timers[0]
+		f.timers = f.timers[1:]
+		t.fire()
+	}
+	f.notify()
+}
+
+// Waiters Amount of timers and sleepers that did not fire yet
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil Waits until there are at least n timers and sleepers that did
+// not fire yet, so a test knows the code under test is waiting on the clock
+// before advancing it
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		pending, changed := len(f.timers), f.changed
+		f.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify Wakes up the goroutines blocked in BlockUntil. Must be called
+// holding mu
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// remove Drops the timer from the pending ones. Must be called holding mu
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, pending := range f.timers {
+		if pending == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.clock.remove(t)
+	if stopped {
+		t.clock.notify()
+	}
+	return stopped
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	active := f.remove(t)
+	t.deadline = f.now.Add(d)
+	if d <= 0 {
+		t.fire()
+	} else {
+		f.timers = append(f.timers, t)
+	}
+	f.notify()
+	return active
+}
+
+// fire Delivers the deadline unless the previous one was not received yet
+func (t *fakeTimer) fire() {
+	select {
+	case t.c <- t.deadline:
+	default:
+	}
+}
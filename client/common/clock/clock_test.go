@@ -0,0 +1,85 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(timer Timer) bool {
+	select {
+	case <-timer.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeFiresTimersWhenAdvanced(t *testing.T) {
+	clock := NewFake(epoch)
+	short := clock.NewTimer(time.Second)
+	long := clock.NewTimer(time.Minute)
+
+	clock.Advance(999 * time.Millisecond)
+	if fired(short) || fired(long) {
+		t.Fatal("timers fired before their deadline")
+	}
+
+	clock.Advance(time.Millisecond)
+	if !fired(short) || fired(long) {
+		t.Fatal("only the short timer should have fired")
+	}
+	if got := clock.Now(); !got.Equal(epoch.Add(time.Second)) {
+		t.Errorf("Now() = %v, want %v", got, epoch.Add(time.Second))
+	}
+
+	clock.Advance(time.Hour)
+	if !fired(long) {
+		t.Fatal("long timer did not fire")
+	}
+	if clock.Waiters() != 0 {
+		t.Errorf("Waiters() = %d, want 0", clock.Waiters())
+	}
+}
+
+func TestFakeStopAndReset(t *testing.T) {
+	clock := NewFake(epoch)
+	timer := clock.NewTimer(time.Second)
+
+	if !timer.Stop() {
+		t.Fatal("Stop() = false for an active timer")
+	}
+	clock.Advance(time.Minute)
+	if fired(timer) {
+		t.Fatal("stopped timer fired")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Fatal("Reset() = true for a stopped timer")
+	}
+	clock.Advance(time.Second)
+	if !fired(timer) {
+		t.Fatal("reset timer did not fire")
+	}
+	if timer.Stop() {
+		t.Fatal("Stop() = true for a fired timer")
+	}
+}
+
+func TestFakeSleepWakesUpWhenAdvanced(t *testing.T) {
+	clock := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Hour)
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return")
+	}
+}
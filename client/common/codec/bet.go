@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxNameLength Maximum length in bytes of the first and last names
+	MaxNameLength = 255
+	// MaxDocumentLength Maximum amount of digits of a document
+	MaxDocumentLength = 10
+	// MaxNumericLength Maximum amount of digits of the agency and the number
+	MaxNumericLength = 9
+	// DateLayout Layout of the birthdate field (YYYY-MM-DD)
+	DateLayout = "2006-01-02"
+)
+
+// FieldError Describes why a field of a bet is not valid
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// BetMessage A bet placed by a person in an agency. Fields are kept in the
+// textual form they have on the wire
+type BetMessage struct {
+	Agency    string
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    string
+}
+
+func (m BetMessage) Type() string {
+	return TypeBet
+}
+
+// Validate Checks every field of the bet against the protocol rules. The
+// first invalid field is reported as a *FieldError
+func (m BetMessage) Validate() error {
+	if err := validateNumeric("agency", m.Agency, MaxNumericLength); err != nil {
+		return err
+	}
+	if err := validateName("first_name", m.FirstName); err != nil {
+		return err
+	}
+	if err := validateName("last_name", m.LastName); err != nil {
+		return err
+	}
+	if err := validateNumeric("document", m.Document, MaxDocumentLength); err != nil {
+		return err
+	}
+	if err := validateDate("birthdate", m.Birthdate); err != nil {
+		return err
+	}
+	return validateNumeric("number", m.Number, MaxNumericLength)
+}
+
+func (m BetMessage) encode(b *strings.Builder) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	writeFields(b, m.Agency, m.FirstName, m.LastName, m.Document, m.Birthdate, m.Number)
+	return nil
+}
+
+func decodeBet(body string) (Message, error) {
+	fields, err := readFields(body, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	bet := BetMessage{
+		Agency:    fields[0],
+		FirstName: fields[1],
+		LastName:  fields[2],
+		Document:  fields[3],
+		Birthdate: fields[4],
+		Number:    fields[5],
+	}
+	if err := bet.Validate(); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+	return bet, nil
+}
+
+func validateName(field string, value string) error {
+	switch {
+	case value == "":
+		return &FieldError{Field: field, Reason: "must not be empty"}
+	case len(value) > MaxNameLength:
+		return &FieldError{Field: field, Reason: fmt.Sprintf("exceeds %d bytes", MaxNameLength)}
+	case !utf8.ValidString(value):
+		return &FieldError{Field: field, Reason: "is not valid UTF-8"}
+	}
+	return nil
+}
+
+func validateNumeric(field string, value string, maxLength int) error {
+	if value == "" {
+		return &FieldError{Field: field, Reason: "must not be empty"}
+	}
+	if len(value) > maxLength {
+		return &FieldError{Field: field, Reason: fmt.Sprintf("exceeds %d digits", maxLength)}
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return &FieldError{Field: field, Reason: fmt.Sprintf("%q is not numeric", truncate(value))}
+		}
+	}
+	return nil
+}
+
+func validateDate(field string, value string) error {
+	if len(value) != len(DateLayout) {
+		return &FieldError{Field: field, Reason: fmt.Sprintf("%q does not match YYYY-MM-DD", truncate(value))}
+	}
+	if _, err := time.Parse(DateLayout, value); err != nil {
+		return &FieldError{Field: field, Reason: fmt.Sprintf("%q is not a valid date", value)}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package codec serializes the messages of the lottery protocol exchanged
+// between the agencies and the central server.
+//
+// Messages are encoded as their type name followed by their fields, every
+// one of them preceded by Delimiter:
+//
+//	[MESSAGE_TYPE][DELIMITER][VALUE_FIELD_1][DELIMITER][VALUE_FIELD_2]...
+//
+// A BetBatchMessage holds its ID followed by serialized BetMessages, each one
+// preceded by RecordDelimiter so the end of a bet field is not confused with
+// the end of a bet:
+//
+//	BetBatchMessage[DELIMITER][BATCH_ID][RECORD_DELIMITER][BetMessage][RECORD_DELIMITER]...
+//
+// Delimiters and Escape are escaped inside field values, so any UTF-8 text
+// survives a round-trip. Messages are carried on the wire by the framing
+// package.
+package codec
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Delimiter Separates the fields of a message
+	Delimiter = '|'
+	// RecordDelimiter Separates the bets inside a BetBatchMessage
+	RecordDelimiter = ';'
+	// Escape Precedes delimiters and itself when they appear inside a field
+	Escape = '\\'
+)
+
+var (
+	// ErrUnknownMessage Returned when decoding a message whose type is not
+	// part of the protocol
+	ErrUnknownMessage = errors.New("unknown message type")
+	// ErrMalformedMessage Returned when a message does not follow the
+	// layout of its type
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// Message A message of the lottery protocol
+type Message interface {
+	// Type Name that identifies the message on the wire
+	Type() string
+	// encode Writes every field of the message after its type name
+	encode(b *strings.Builder) error
+}
+
+// decoders Parse the body of a message (everything after its type name)
+// indexed by message type
+var decoders = map[string]func(body string) (Message, error){}
+
+// Encode Serializes the message. Messages holding invalid values are not
+// encoded and the validation error is returned instead
+func Encode(m Message) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString(m.Type())
+	if err := m.encode(&b); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// Decode Parses a serialized message. Decoding never trusts the announced
+// amounts of the payload and fails on any field that does not follow the
+// protocol rules
+func Decode(payload []byte) (Message, error) {
+	s := string(payload)
+	if !utf8.ValidString(s) {
+		return nil, errors.Wrap(ErrMalformedMessage, "payload is not valid UTF-8")
+	}
+
+	msgType, body := s, ""
+	if i := strings.IndexAny(s, string([]byte{Delimiter, RecordDelimiter})); i >= 0 {
+		msgType, body = s[:i], s[i:]
+	}
+
+	decode, ok := decoders[msgType]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownMessage, "%q", truncate(msgType))
+	}
+	return decode(body)
+}
+
+// writeFields Writes each field preceded by Delimiter, escaping its value
+func writeFields(b *strings.Builder, fields ...string) {
+	for _, field := range fields {
+		b.WriteByte(Delimiter)
+		b.WriteString(escape(field))
+	}
+}
+
+// readFields Parses a body made of exactly n fields
+func readFields(body string, n int) ([]string, error) {
+	fields, err := readAllFields(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != n {
+		return nil, errors.Wrapf(ErrMalformedMessage, "expected %d fields, got %d", n, len(fields))
+	}
+	return fields, nil
+}
+
+// readAllFields Parses a body made of any amount of fields
+func readAllFields(body string) ([]string, error) {
+	if body == "" {
+		return nil, nil
+	}
+	if body[0] != Delimiter {
+		return nil, errors.Wrap(ErrMalformedMessage, "fields must be preceded by the delimiter")
+	}
+
+	fields := split(body[1:], Delimiter)
+	for i, field := range fields {
+		value, err := unescape(field)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = value
+	}
+	return fields, nil
+}
+
+// escape Prefixes every delimiter and escape character with Escape
+func escape(s string) string {
+	if !strings.ContainsAny(s, string([]byte{Delimiter, RecordDelimiter, Escape})) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if isSpecial(s[i]) {
+			b.WriteByte(Escape)
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescape Reverts escape. Unescaped delimiters and dangling or unnecessary
+// escape characters are rejected so every value has a single encoding
+func unescape(s string) (string, error) {
+	if !strings.ContainsAny(s, string([]byte{Delimiter, RecordDelimiter, Escape})) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == Escape:
+			if i+1 == len(s) || !isSpecial(s[i+1]) {
+				return "", errors.Wrap(ErrMalformedMessage, "invalid escape sequence")
+			}
+			i++
+		case isSpecial(s[i]):
+			return "", errors.Wrapf(ErrMalformedMessage, "unescaped delimiter %q", s[i])
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// split Slices s around every sep that is not escaped. Escape sequences are
+// kept untouched so they can be unescaped later
+func split(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case Escape:
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func isSpecial(c byte) bool {
+	return c == Delimiter || c == RecordDelimiter || c == Escape
+}
+
+// truncate Shortens untrusted values before including them in errors
+func truncate(s string) string {
+	const maxLength = 32
+	if len(s) <= maxLength {
+		return s
+	}
+	return s[:maxLength] + "..."
+}
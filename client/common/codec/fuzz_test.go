@@ -0,0 +1,141 @@
+//go:build go1.18
+// +build go1.18
+
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// seedBets Bets added to the corpus of every fuzz target. They cover
+// multibyte UTF-8 names and names holding the characters the codec escapes
+var seedBets = []BetMessage{
+	{Agency: "1", FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"},
+	{Agency: "2", FirstName: "Nicolás", LastName: "Peña", Document: "27726965", Birthdate: "1994-03-16", Number: "7574"},
+	{Agency: "3", FirstName: "María Inés", LastName: "Muñoz Güemes", Document: "24260718", Birthdate: "1987-08-01", Number: "8676"},
+	{Agency: "4", FirstName: "Ana|María", LastName: "O;Brien", Document: "29369913", Birthdate: "1989-12-05", Number: "6857"},
+	{Agency: "5", FirstName: `Jo\sé`, LastName: `\|;`, Document: "1", Birthdate: "2000-02-29", Number: "0"},
+}
+
+func FuzzBetRoundTrip(f *testing.F) {
+	for _, bet := range seedBets {
+		f.Add(bet.Agency, bet.FirstName, bet.LastName, bet.Document, bet.Birthdate, bet.Number)
+	}
+
+	f.Fuzz(func(t *testing.T, agency, firstName, lastName, document, birthdate, number string) {
+		bet := BetMessage{
+			Agency:    agency,
+			FirstName: firstName,
+			LastName:  lastName,
+			Document:  document,
+			Birthdate: birthdate,
+			Number:    number,
+		}
+		if bet.Validate() != nil {
+			if _, err := Encode(bet); err == nil {
+				t.Fatalf("Encode() accepted the invalid bet %+v", bet)
+			}
+			return
+		}
+
+		payload, err := Encode(bet)
+		if err != nil {
+			t.Fatalf("Encode(%+v) = %v", bet, err)
+		}
+		decoded, err := Decode(payload)
+		if err != nil {
+			t.Fatalf("Decode(%q) = %v", payload, err)
+		}
+		if decoded != bet {
+			t.Fatalf("round trip = %+v, want %+v", decoded, bet)
+		}
+	})
+}
+
+func FuzzBatchRoundTrip(f *testing.F) {
+	for i, bet := range seedBets {
+		f.Add(uint64(i), uint64(0), bet.FirstName, bet.LastName)
+	}
+	f.Add(uint64(1<<64-1), uint64(1<<64-1), "Ñandú", "Ibáñez")
+
+	f.Fuzz(func(t *testing.T, id, round uint64, firstName, lastName string) {
+		batch := BetBatchMessage{ID: id, Round: round}
+		for _, bet := range seedBets {
+			bet.FirstName = firstName
+			bet.LastName = lastName
+			if bet.Validate() != nil {
+				return
+			}
+			batch.Bets = append(batch.Bets, bet)
+		}
+
+		payload, err := Encode(batch)
+		if err != nil {
+			t.Fatalf("Encode(%+v) = %v", batch, err)
+		}
+		decoded, err := Decode(payload)
+		if err != nil {
+			t.Fatalf("Decode(%q) = %v", payload, err)
+		}
+		if !reflect.DeepEqual(decoded, batch) {
+			t.Fatalf("round trip = %+v, want %+v", decoded, batch)
+		}
+	})
+}
+
+// FuzzDecode Decoding arbitrary bytes must never panic, and every message
+// accepted must be encoded back to the very same bytes since the encoding
+// is canonical
+func FuzzDecode(f *testing.F) {
+	for _, bet := range seedBets {
+		payload, _ := Encode(BetBatchMessage{ID: 1, Bets: []BetMessage{bet, bet}})
+		f.Add(payload)
+	}
+	for _, msg := range []Message{
+		AckMessage{BatchID: 7, Status: AckBusy},
+		EndOfBetsMessage{Agency: "1"},
+		WinnersRequestMessage{Agency: "1"},
+		WinnersPendingMessage{},
+		DrawCompleteMessage{},
+		RegisterMessage{Agency: "1"},
+		RegisterMessage{Agency: "1", Round: 3},
+		EndOfBetsMessage{Agency: "1", Round: 3},
+		WinnersRequestMessage{Agency: "1", Round: 3},
+		BetBatchMessage{ID: 1, Round: 3},
+		RegisteredMessage{Status: RegisterClosed},
+		DrawMissedMessage{},
+		UnknownRoundMessage{},
+		RegisteredMessage{Status: RegisterOK, Commitment: strings.Repeat("0f", 32)},
+		RevealRequestMessage{},
+		RevealRequestMessage{Round: 3},
+		RevealMessage{Number: "7574", Seed: strings.Repeat("a1", 32)},
+		WinnersNotificationMessage{Documents: []string{"30904465", "27726965"}},
+		PingMessage{},
+	} {
+		payload, _ := Encode(msg)
+		f.Add(payload)
+	}
+	f.Add([]byte("BetMessage|1|Ana\\|María|Peña|1|2000-01-01|1"))
+	f.Add([]byte("BetBatchMessage|01;"))
+	f.Add([]byte("EndOfBetsMessage|1|0"))
+	f.Add([]byte("AckMessage|1|9"))
+	f.Add([]byte("WinnersNotificationMessage|99999999999|1"))
+	f.Add([]byte("BetMessage|1|\xff|Peña|1|2000-01-01|1"))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		msg, err := Decode(payload)
+		if err != nil {
+			return
+		}
+		encoded, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode(Decode(%q)) = %v", payload, err)
+		}
+		if !bytes.Equal(encoded, payload) {
+			t.Fatalf("Encode(Decode(%q)) = %q", payload, encoded)
+		}
+	})
+}
@@ -0,0 +1,631 @@
+package codec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Message types as written on the wire
+const (
+	TypeBet                 = "BetMessage"
+	TypeBetBatch            = "BetBatchMessage"
+	TypeAck                 = "AckMessage"
+	TypeEndOfBets           = "EndOfBetsMessage"
+	TypeWinnersRequest      = "WinnersRequestMessage"
+	TypeWinnersPending      = "WinnersPendingMessage"
+	TypeWinnersNotification = "WinnersNotificationMessage"
+	TypePing                = "PingMessage"
+	TypePong                = "PongMessage"
+	TypeDrawComplete        = "DrawCompleteMessage"
+	TypeRegister            = "RegisterMessage"
+	TypeRegistered          = "RegisteredMessage"
+	TypeDrawMissed          = "DrawMissedMessage"
+	TypeRevealRequest       = "RevealRequestMessage"
+	TypeReveal              = "RevealMessage"
+	TypeUnknownRound        = "UnknownRoundMessage"
+)
+
+// DefaultRound Round of the bets and queries that do not pick one
+const DefaultRound uint64 = 0
+
+// HashLength Characters of the hex encoded commitments and seeds of the
+// draws
+const HashLength = 64
+
+func init() {
+	decoders[TypeBet] = decodeBet
+	decoders[TypeBetBatch] = decodeBetBatch
+	decoders[TypeAck] = decodeAck
+	decoders[TypeEndOfBets] = decodeEndOfBets
+	decoders[TypeWinnersRequest] = decodeWinnersRequest
+	decoders[TypeWinnersPending] = decodeWinnersPending
+	decoders[TypeWinnersNotification] = decodeWinnersNotification
+	decoders[TypePing] = decodePing
+	decoders[TypePong] = decodePong
+	decoders[TypeDrawComplete] = decodeDrawComplete
+	decoders[TypeRegister] = decodeRegister
+	decoders[TypeRegistered] = decodeRegistered
+	decoders[TypeDrawMissed] = decodeDrawMissed
+	decoders[TypeRevealRequest] = decodeRevealRequest
+	decoders[TypeReveal] = decodeReveal
+	decoders[TypeUnknownRound] = decodeUnknownRound
+}
+
+// BetBatchMessage Sent by an agency to register several bets at once. The
+// ID identifies the batch within the agency so its ack can be matched and
+// retransmissions can be recognized by the server. The bets take part in
+// the draw of the given round
+type BetBatchMessage struct {
+	ID    uint64
+	Round uint64
+	Bets  []BetMessage
+}
+
+func (m BetBatchMessage) Type() string {
+	return TypeBetBatch
+}
+
+func (m BetBatchMessage) encode(b *strings.Builder) error {
+	writeFields(b, strconv.FormatUint(m.ID, 10))
+	writeRound(b, m.Round)
+	for _, bet := range m.Bets {
+		b.WriteByte(RecordDelimiter)
+		b.WriteString(bet.Type())
+		if err := bet.encode(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeBetBatch(body string) (Message, error) {
+	records := split(body, RecordDelimiter)
+
+	fields, round, err := readFieldsAndRound(records[0], 1)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseID(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	batch := BetBatchMessage{ID: id, Round: round}
+	for _, record := range records[1:] {
+		msg, err := Decode([]byte(record))
+		if err != nil {
+			return nil, err
+		}
+		bet, ok := msg.(BetMessage)
+		if !ok {
+			return nil, errors.Wrapf(ErrMalformedMessage, "batch holds a %s", msg.Type())
+		}
+		batch.Bets = append(batch.Bets, bet)
+	}
+	return batch, nil
+}
+
+// AckStatus Result of processing a batch
+type AckStatus int
+
+const (
+	// AckOK Every bet of the batch was stored
+	AckOK AckStatus = iota
+	// AckRejected Some bet of the batch is not valid, none of them was
+	// stored. Sending the batch again will not succeed
+	AckRejected
+	// AckBusy The server is overloaded and did not process the batch. The
+	// batch must be sent again and the sender should slow down
+	AckBusy
+)
+
+func (s AckStatus) String() string {
+	switch s {
+	case AckOK:
+		return "ok"
+	case AckRejected:
+		return "rejected"
+	case AckBusy:
+		return "busy"
+	}
+	return "unknown(" + strconv.Itoa(int(s)) + ")"
+}
+
+// AckMessage Sent by the server to report the result of processing the
+// batch with the given ID
+type AckMessage struct {
+	BatchID uint64
+	Status  AckStatus
+}
+
+func (m AckMessage) Type() string {
+	return TypeAck
+}
+
+func (m AckMessage) encode(b *strings.Builder) error {
+	writeFields(b, strconv.FormatUint(m.BatchID, 10), strconv.Itoa(int(m.Status)))
+	return nil
+}
+
+func decodeAck(body string) (Message, error) {
+	fields, err := readFields(body, 2)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseID(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	status, err := parseAckStatus(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return AckMessage{BatchID: id, Status: status}, nil
+}
+
+// EndOfBetsMessage Sent by an agency once all of its bets for the round
+// were sent
+type EndOfBetsMessage struct {
+	Agency string
+	Round  uint64
+}
+
+func (m EndOfBetsMessage) Type() string {
+	return TypeEndOfBets
+}
+
+func (m EndOfBetsMessage) encode(b *strings.Builder) error {
+	if err := validateNumeric("agency", m.Agency, MaxNumericLength); err != nil {
+		return err
+	}
+	writeFields(b, m.Agency)
+	writeRound(b, m.Round)
+	return nil
+}
+
+func decodeEndOfBets(body string) (Message, error) {
+	agency, round, err := readAgencyAndRound(body)
+	if err != nil {
+		return nil, err
+	}
+	return EndOfBetsMessage{Agency: agency, Round: round}, nil
+}
+
+// WinnersRequestMessage Sent by an agency to ask for its winners in the
+// draw of the round
+type WinnersRequestMessage struct {
+	Agency string
+	Round  uint64
+}
+
+func (m WinnersRequestMessage) Type() string {
+	return TypeWinnersRequest
+}
+
+func (m WinnersRequestMessage) encode(b *strings.Builder) error {
+	if err := validateNumeric("agency", m.Agency, MaxNumericLength); err != nil {
+		return err
+	}
+	writeFields(b, m.Agency)
+	writeRound(b, m.Round)
+	return nil
+}
+
+func decodeWinnersRequest(body string) (Message, error) {
+	agency, round, err := readAgencyAndRound(body)
+	if err != nil {
+		return nil, err
+	}
+	return WinnersRequestMessage{Agency: agency, Round: round}, nil
+}
+
+// WinnersPendingMessage Sent by the server when winners are requested before
+// every agency finished sending its bets
+type WinnersPendingMessage struct{}
+
+func (m WinnersPendingMessage) Type() string {
+	return TypeWinnersPending
+}
+
+func (m WinnersPendingMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodeWinnersPending(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return WinnersPendingMessage{}, nil
+}
+
+// WinnersNotificationMessage Sent by the server with the documents of the
+// winners of the agency that requested them
+type WinnersNotificationMessage struct {
+	Documents []string
+}
+
+func (m WinnersNotificationMessage) Type() string {
+	return TypeWinnersNotification
+}
+
+func (m WinnersNotificationMessage) encode(b *strings.Builder) error {
+	for _, document := range m.Documents {
+		if err := validateNumeric("document", document, MaxDocumentLength); err != nil {
+			return err
+		}
+	}
+	writeFields(b, strconv.Itoa(len(m.Documents)))
+	writeFields(b, m.Documents...)
+	return nil
+}
+
+func decodeWinnersNotification(body string) (Message, error) {
+	fields, err := readAllFields(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.Wrap(ErrMalformedMessage, "missing winners count")
+	}
+
+	// Leading zeros or signs are not part of the canonical form
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || strconv.Itoa(count) != fields[0] || count != len(fields)-1 {
+		return nil, errors.Wrapf(ErrMalformedMessage, "winners count %q does not match %d documents", truncate(fields[0]), len(fields)-1)
+	}
+
+	var documents []string
+	if count > 0 {
+		documents = fields[1:]
+	}
+	for _, document := range documents {
+		if err := validateNumeric("document", document, MaxDocumentLength); err != nil {
+			return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+		}
+	}
+	return WinnersNotificationMessage{Documents: documents}, nil
+}
+
+// PingMessage Sent by anyone that wants to know whether the server is able
+// to attend requests
+type PingMessage struct{}
+
+func (m PingMessage) Type() string {
+	return TypePing
+}
+
+func (m PingMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodePing(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return PingMessage{}, nil
+}
+
+// PongMessage Sent by the server to answer a PingMessage
+type PongMessage struct{}
+
+func (m PongMessage) Type() string {
+	return TypePong
+}
+
+func (m PongMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodePong(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return PongMessage{}, nil
+}
+
+// DrawCompleteMessage Pushed by the server, through the connection an
+// agency notified the end of its bets with, once the draw took place. The
+// agency then asks for its winners as usual
+type DrawCompleteMessage struct{}
+
+func (m DrawCompleteMessage) Type() string {
+	return TypeDrawComplete
+}
+
+func (m DrawCompleteMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodeDrawComplete(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return DrawCompleteMessage{}, nil
+}
+
+// RegisterMessage Sent by an agency as the first message of the connection
+// its bets are sent through, so the server knows it takes part in the draw
+// of the round
+type RegisterMessage struct {
+	Agency string
+	Round  uint64
+}
+
+func (m RegisterMessage) Type() string {
+	return TypeRegister
+}
+
+func (m RegisterMessage) encode(b *strings.Builder) error {
+	if err := validateNumeric("agency", m.Agency, MaxNumericLength); err != nil {
+		return err
+	}
+	writeFields(b, m.Agency)
+	writeRound(b, m.Round)
+	return nil
+}
+
+func decodeRegister(body string) (Message, error) {
+	agency, round, err := readAgencyAndRound(body)
+	if err != nil {
+		return nil, err
+	}
+	return RegisterMessage{Agency: agency, Round: round}, nil
+}
+
+// RegisterStatus Result of the registration of an agency
+type RegisterStatus int
+
+const (
+	// RegisterOK The agency takes part in the draw
+	RegisterOK RegisterStatus = iota
+	// RegisterUnknown The agency is not in the roster of the draw
+	RegisterUnknown
+	// RegisterClosed The draw already took place without the agency
+	RegisterClosed
+)
+
+func (s RegisterStatus) String() string {
+	switch s {
+	case RegisterOK:
+		return "ok"
+	case RegisterUnknown:
+		return "unknown"
+	case RegisterClosed:
+		return "closed"
+	}
+	return "unknown(" + strconv.Itoa(int(s)) + ")"
+}
+
+// RegisteredMessage Sent by the server to answer a RegisterMessage. When
+// the server picks the winning number of the round at random it commits to
+// it, sending the commitment along with a RegisterOK status
+type RegisteredMessage struct {
+	Status     RegisterStatus
+	Commitment string
+}
+
+func (m RegisteredMessage) Type() string {
+	return TypeRegistered
+}
+
+func (m RegisteredMessage) encode(b *strings.Builder) error {
+	writeFields(b, strconv.Itoa(int(m.Status)))
+	if m.Commitment != "" {
+		if err := validateHash("commitment", m.Commitment); err != nil {
+			return err
+		}
+		writeFields(b, m.Commitment)
+	}
+	return nil
+}
+
+func decodeRegistered(body string) (Message, error) {
+	fields, err := readAllFields(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 1 && len(fields) != 2 {
+		return nil, errors.Wrapf(ErrMalformedMessage, "expected a status and an optional commitment, got %d fields", len(fields))
+	}
+
+	var msg RegisteredMessage
+	switch fields[0] {
+	case "0":
+		msg.Status = RegisterOK
+	case "1":
+		msg.Status = RegisterUnknown
+	case "2":
+		msg.Status = RegisterClosed
+	default:
+		return nil, errors.Wrapf(ErrMalformedMessage, "%q is not a register status", truncate(fields[0]))
+	}
+	if len(fields) == 2 {
+		if err := validateHash("commitment", fields[1]); err != nil {
+			return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+		}
+		msg.Commitment = fields[1]
+	}
+	return msg, nil
+}
+
+// DrawMissedMessage Sent by the server, instead of the winners or the draw
+// push, to an agency whose bets were not part of the draw because it did
+// not finish sending them before the draw deadline
+type DrawMissedMessage struct{}
+
+func (m DrawMissedMessage) Type() string {
+	return TypeDrawMissed
+}
+
+func (m DrawMissedMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodeDrawMissed(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return DrawMissedMessage{}, nil
+}
+
+// UnknownRoundMessage Sent by the server, instead of the winners or the
+// winning number, when no agency registered, sent bets or finished for the
+// round asked about, such as a round yet to start
+type UnknownRoundMessage struct{}
+
+func (m UnknownRoundMessage) Type() string {
+	return TypeUnknownRound
+}
+
+func (m UnknownRoundMessage) encode(b *strings.Builder) error {
+	return nil
+}
+
+func decodeUnknownRound(body string) (Message, error) {
+	if _, err := readFields(body, 0); err != nil {
+		return nil, err
+	}
+	return UnknownRoundMessage{}, nil
+}
+
+// RevealRequestMessage Sent by an agency to learn the winning number of
+// the round and the seed it was derived from, once the draw took place
+type RevealRequestMessage struct {
+	Round uint64
+}
+
+func (m RevealRequestMessage) Type() string {
+	return TypeRevealRequest
+}
+
+func (m RevealRequestMessage) encode(b *strings.Builder) error {
+	writeRound(b, m.Round)
+	return nil
+}
+
+func decodeRevealRequest(body string) (Message, error) {
+	_, round, err := readFieldsAndRound(body, 0)
+	if err != nil {
+		return nil, err
+	}
+	return RevealRequestMessage{Round: round}, nil
+}
+
+// RevealMessage Sent by the server to answer a RevealRequestMessage with
+// the winning number of the round and the seed it committed to. The server
+// answers with a WinnersPendingMessage before the draw
+type RevealMessage struct {
+	Number string
+	Seed   string
+}
+
+func (m RevealMessage) Type() string {
+	return TypeReveal
+}
+
+func (m RevealMessage) encode(b *strings.Builder) error {
+	if err := validateNumeric("number", m.Number, MaxNumericLength); err != nil {
+		return err
+	}
+	if err := validateHash("seed", m.Seed); err != nil {
+		return err
+	}
+	writeFields(b, m.Number, m.Seed)
+	return nil
+}
+
+func decodeReveal(body string) (Message, error) {
+	fields, err := readFields(body, 2)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateNumeric("number", fields[0], MaxNumericLength); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+	if err := validateHash("seed", fields[1]); err != nil {
+		return nil, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+	return RevealMessage{Number: fields[0], Seed: fields[1]}, nil
+}
+
+// validateHash Checks the value is a lowercase hex encoded hash, which is
+// its only encoding
+func validateHash(field string, value string) error {
+	if len(value) != HashLength {
+		return &FieldError{Field: field, Reason: fmt.Sprintf("must hold %d hex digits", HashLength)}
+	}
+	for i := 0; i < len(value); i++ {
+		if (value[i] < '0' || value[i] > '9') && (value[i] < 'a' || value[i] > 'f') {
+			return &FieldError{Field: field, Reason: fmt.Sprintf("%q is not lowercase hex", truncate(value))}
+		}
+	}
+	return nil
+}
+
+// writeRound Writes the round as the last field. The default round is
+// left out so messages that do not pick one keep their original encoding
+func writeRound(b *strings.Builder, round uint64) {
+	if round != DefaultRound {
+		writeFields(b, strconv.FormatUint(round, 10))
+	}
+}
+
+// readFieldsAndRound Parses a body made of n fields optionally followed by
+// a round
+func readFieldsAndRound(body string, n int) ([]string, uint64, error) {
+	fields, err := readAllFields(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(fields) != n && len(fields) != n+1 {
+		return nil, 0, errors.Wrapf(ErrMalformedMessage, "expected %d fields and an optional round, got %d", n, len(fields))
+	}
+	if len(fields) == n {
+		return fields, DefaultRound, nil
+	}
+
+	round, err := parseID(fields[n])
+	if err != nil {
+		return nil, 0, err
+	}
+	// The default round is never written
+	if round == DefaultRound {
+		return nil, 0, errors.Wrap(ErrMalformedMessage, "the default round is implicit")
+	}
+	return fields[:n], round, nil
+}
+
+func readAgencyAndRound(body string) (string, uint64, error) {
+	fields, round, err := readFieldsAndRound(body, 1)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := validateNumeric("agency", fields[0], MaxNumericLength); err != nil {
+		return "", 0, errors.Wrap(ErrMalformedMessage, err.Error())
+	}
+	return fields[0], round, nil
+}
+
+func parseID(value string) (uint64, error) {
+	// Leading zeros or signs are not part of the canonical form
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || strconv.FormatUint(id, 10) != value {
+		return 0, errors.Wrapf(ErrMalformedMessage, "%q is not a valid id", truncate(value))
+	}
+	return id, nil
+}
+
+func parseAckStatus(value string) (AckStatus, error) {
+	switch value {
+	case "0":
+		return AckOK, nil
+	case "1":
+		return AckRejected, nil
+	case "2":
+		return AckBusy, nil
+	}
+	return 0, errors.Wrapf(ErrMalformedMessage, "%q is not an ack status", truncate(value))
+}
@@ -0,0 +1,67 @@
+// Package draw implements the commit-reveal scheme a server may use to pick
+// the winning number of a round. The server commits to a random seed by
+// publishing its hash before the bets close and reveals the seed once the
+// draw took place. The winning number is derived from the seed, so agencies
+// can check it was not picked after the bets were known.
+package draw
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SeedSize Random bytes of a seed
+	SeedSize = 32
+	// NumberRange Winning numbers go from 0 up to NumberRange, exclusive,
+	// like the numbers of the bets
+	NumberRange = 10000
+)
+
+var (
+	// ErrCommitmentMismatch Returned when the revealed seed is not the one
+	// the server committed to
+	ErrCommitmentMismatch = errors.New("revealed seed does not match the commitment")
+	// ErrNumberMismatch Returned when the announced winning number is not
+	// the one derived from the revealed seed
+	ErrNumberMismatch = errors.New("winning number does not match the revealed seed")
+)
+
+// NewSeed Returns a random seed, hex encoded
+func NewSeed() (string, error) {
+	seed := make([]byte, SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return "", errors.Wrap(err, "could not generate a seed")
+	}
+	return hex.EncodeToString(seed), nil
+}
+
+// Commitment Hash of the seed published before the bets close, hex encoded
+func Commitment(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// WinningNumber Number derived from the seed. The hash is tagged so the
+// number cannot be read from the commitment
+func WinningNumber(seed string) string {
+	sum := sha256.Sum256([]byte("winning-number:" + seed))
+	return strconv.FormatUint(binary.BigEndian.Uint64(sum[:8])%NumberRange, 10)
+}
+
+// Verify Checks that the seed is the one behind commitment and that number
+// was derived from it
+func Verify(commitment string, seed string, number string) error {
+	if Commitment(seed) != commitment {
+		return ErrCommitmentMismatch
+	}
+	if WinningNumber(seed) != number {
+		return ErrNumberMismatch
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package draw
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestVerifyAcceptsTheRevealedDraw(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed) != 2*SeedSize {
+		t.Fatalf("seed %q holds %d characters, want %d", seed, len(seed), 2*SeedSize)
+	}
+
+	number := WinningNumber(seed)
+	if n, err := strconv.Atoi(number); err != nil || n < 0 || n >= NumberRange {
+		t.Fatalf("WinningNumber() = %q, want a number below %d", number, NumberRange)
+	}
+	if err := Verify(Commitment(seed), seed, number); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedDraws(t *testing.T) {
+	seed, _ := NewSeed()
+	other, _ := NewSeed()
+	commitment := Commitment(seed)
+
+	tests := []struct {
+		name   string
+		seed   string
+		number string
+		want   error
+	}{
+		{name: "another seed", seed: other, number: WinningNumber(other), want: ErrCommitmentMismatch},
+		{name: "another number", seed: seed, number: WinningNumber(seed) + "0", want: ErrNumberMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(commitment, tt.seed, tt.number); err != tt.want {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EndpointPolicy How the client picks the server endpoint of every
+// connection among the healthy ones
+type EndpointPolicy string
+
+const (
+	// Failover Always the first healthy endpoint, in the configured order
+	Failover EndpointPolicy = "failover"
+	// RoundRobin Every healthy endpoint in turns
+	RoundRobin EndpointPolicy = "round_robin"
+	// Random Any healthy endpoint
+	Random EndpointPolicy = "random"
+)
+
+// endpointRetryPeriod Time an endpoint is avoided after a failure
+const endpointRetryPeriod = 5 * time.Second
+
+// ParseEndpoints Splits a comma separated list of server addresses
+func ParseEndpoints(addresses string) []string {
+	var endpoints []string
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			endpoints = append(endpoints, address)
+		}
+	}
+	return endpoints
+}
+
+// endpoint Server address along with its health
+type endpoint struct {
+	address     string
+	failed      bool
+	lastFailure time.Time
+}
+
+// endpointSet Server endpoints the client can connect to. An endpoint is
+// unhealthy from the moment a connection to it fails until a later
+// connection succeeds. Unhealthy endpoints are avoided for a retry period,
+// unless every endpoint is unhealthy. Every method is safe for concurrent
+// use
+type endpointSet struct {
+	policy     EndpointPolicy
+	retryAfter time.Duration
+	onChange   func(address string, healthy bool)
+	now        func() time.Time
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	rand      *rand.Rand
+}
+
+// newEndpointSet Returns the endpoints of the given addresses, all of them
+// healthy. The failover policy is used when none is given
+func newEndpointSet(addresses []string, policy EndpointPolicy, onChange func(address string, healthy bool)) (*endpointSet, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no server address configured")
+	}
+	switch policy {
+	case "":
+		policy = Failover
+	case Failover, RoundRobin, Random:
+	default:
+		return nil, errors.Errorf("unknown endpoint policy %q", policy)
+	}
+
+	s := &endpointSet{
+		policy:     policy,
+		retryAfter: endpointRetryPeriod,
+		onChange:   onChange,
+		now:        time.Now,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, address := range addresses {
+		s.endpoints = append(s.endpoints, &endpoint{address: address})
+	}
+	return s, nil
+}
+
+// pick Returns the address the next connection should use
+func (s *endpointSet) pick() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var candidates []*endpoint
+	for _, e := range s.endpoints {
+		if !e.failed || now.Sub(e.lastFailure) >= s.retryAfter {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = s.endpoints
+	}
+
+	switch s.policy {
+	case RoundRobin:
+		e := candidates[s.next%len(candidates)]
+		s.next++
+		return e.address
+	case Random:
+		return candidates[s.rand.Intn(len(candidates))].address
+	}
+	return candidates[0].address
+}
+
+// success Marks the endpoint as healthy
+func (s *endpointSet) success(address string) {
+	s.update(address, false)
+}
+
+// failure Marks the endpoint as unhealthy
+func (s *endpointSet) failure(address string) {
+	s.update(address, true)
+}
+
+func (s *endpointSet) update(address string, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.endpoints {
+		if e.address != address {
+			continue
+		}
+		changed := e.failed != failed
+		e.failed = failed
+		if failed {
+			e.lastFailure = s.now()
+		}
+		if changed && s.onChange != nil {
+			s.onChange(address, !failed)
+		}
+	}
+}
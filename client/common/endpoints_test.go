@@ -0,0 +1,113 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointPolicies(t *testing.T) {
+	addresses := []string{"a:1", "b:1", "c:1"}
+	tests := []struct {
+		policy EndpointPolicy
+		want   []string
+	}{
+		{policy: Failover, want: []string{"a:1", "a:1", "a:1", "a:1"}},
+		{policy: RoundRobin, want: []string{"a:1", "b:1", "c:1", "a:1"}},
+	}
+
+	for _, tt := range tests {
+		set, err := newEndpointSet(addresses, tt.policy, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for range tt.want {
+			got = append(got, set.pick())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s picks = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+
+	set, _ := newEndpointSet(addresses, Random, nil)
+	for i := 0; i < 10; i++ {
+		if address := set.pick(); !strings.HasSuffix(address, ":1") {
+			t.Fatalf("random pick = %q", address)
+		}
+	}
+
+	if _, err := newEndpointSet(addresses, "closest", nil); err == nil {
+		t.Error("newEndpointSet() with an unknown policy did not fail")
+	}
+}
+
+func TestEndpointSetAvoidsFailedEndpoints(t *testing.T) {
+	now := time.Unix(0, 0)
+	var changes []string
+	set, _ := newEndpointSet([]string{"a:1", "b:1"}, Failover, func(address string, healthy bool) {
+		if !healthy {
+			address = "-" + address
+		}
+		changes = append(changes, address)
+	})
+	set.now = func() time.Time { return now }
+
+	set.failure("a:1")
+	if address := set.pick(); address != "b:1" {
+		t.Errorf("pick() after the primary failed = %q, want b:1", address)
+	}
+
+	// Once every endpoint failed they are all candidates again
+	set.failure("b:1")
+	if address := set.pick(); address != "a:1" {
+		t.Errorf("pick() with every endpoint failed = %q, want a:1", address)
+	}
+
+	// The primary is retried after the retry period
+	set.success("b:1")
+	now = now.Add(endpointRetryPeriod)
+	if address := set.pick(); address != "a:1" {
+		t.Errorf("pick() after the retry period = %q, want a:1", address)
+	}
+
+	want := "-a:1,-b:1,b:1"
+	if strings.Join(changes, ",") != want {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestClientFailsOverToHealthyEndpoint(t *testing.T) {
+	server := startServer(t, 1)
+	// Nothing listens on the address of a closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	config := testConfig(server, manyBetsFile(t, 4))
+	config.ServerAddresses = []string{down, server.Addr}
+	config.BatchMaxRetries = 2
+	config.WaitTimeout = time.Second
+	client := NewClient(config)
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	assertBetsStoredOnce(t, server, 4)
+
+	var buf bytes.Buffer
+	client.Metrics().WriteTo(&buf)
+	for _, line := range []string{
+		`lottery_client_endpoint_healthy{address="` + down + `"} 0`,
+		`lottery_client_endpoint_healthy{address="` + server.Addr + `"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+}
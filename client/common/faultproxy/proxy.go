@@ -0,0 +1,271 @@
+// Package faultproxy provides a TCP proxy that injects faults between the
+// client and the server: latency, bandwidth limits, fragmented writes,
+// connection resets and half-open connections. The faults of every
+// connection are described by a Scenario, so failure paths can be
+// exercised deterministically in tests or by hand through the faultproxy
+// command.
+package faultproxy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// dialTimeout Time the proxy waits for the target to accept a connection
+const dialTimeout = 5 * time.Second
+
+// Proxy Forwards every connection accepted to the target address, injecting
+// the faults of its scenario
+type Proxy struct {
+	// Addr Address the proxy listens on, in the form host:port
+	Addr string
+
+	listener net.Listener
+	target   string
+	scenario Scenario
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	accepted int
+	sessions map[*session]bool
+	closed   bool
+}
+
+// New Starts a proxy on a random loopback port
+func New(target string, scenario Scenario) (*Proxy, error) {
+	return Listen("127.0.0.1:0", target, scenario)
+}
+
+// Listen Starts a proxy on the given address that forwards connections to
+// target
+func Listen(address string, target string, scenario Scenario) (*Proxy, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		target:   target,
+		scenario: scenario,
+		sessions: make(map[*session]bool),
+	}
+	p.wg.Add(1)
+	go p.acceptLoop()
+	return p, nil
+}
+
+// Accepted Amount of connections accepted so far
+func (p *Proxy) Accepted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.accepted
+}
+
+// Close Stops accepting connections, closes the open ones and waits for
+// every forwarding goroutine to return
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for s := range p.sessions {
+		s.close(false)
+	}
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) acceptLoop() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.accepted++
+		number := p.accepted
+		p.mu.Unlock()
+
+		p.wg.Add(1)
+		go p.handleConnection(number, conn)
+	}
+}
+
+// handleConnection Connects to the target and forwards the traffic in both
+// directions until either end closes its connection. The client connection
+// is closed right away if the target cannot be reached
+func (p *Proxy) handleConnection(number int, client net.Conn) {
+	defer p.wg.Done()
+
+	server, err := net.DialTimeout("tcp", p.target, dialTimeout)
+	if err != nil {
+		client.Close()
+		return
+	}
+
+	s := &session{
+		client:   client,
+		server:   server,
+		done:     make(chan struct{}),
+		halfOpen: make(chan struct{}),
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		s.close(false)
+		return
+	}
+	p.sessions[s] = true
+	p.mu.Unlock()
+
+	var forwarding sync.WaitGroup
+	forwarding.Add(2)
+	go func() {
+		defer forwarding.Done()
+		s.forward(client, server, p.scenario.linkFor(number, Upstream))
+	}()
+	go func() {
+		defer forwarding.Done()
+		s.forward(server, client, p.scenario.linkFor(number, Downstream))
+	}()
+	forwarding.Wait()
+
+	p.mu.Lock()
+	delete(p.sessions, s)
+	p.mu.Unlock()
+}
+
+// session Pair of connections joined by the proxy
+type session struct {
+	client net.Conn
+	server net.Conn
+
+	closeOnce    sync.Once
+	done         chan struct{}
+	halfOpenOnce sync.Once
+	halfOpen     chan struct{}
+}
+
+// close Closes both connections. When reset is set they are closed
+// discarding unsent data, so both peers receive a reset
+func (s *session) close(reset bool) {
+	s.closeOnce.Do(func() {
+		for _, conn := range []net.Conn{s.client, s.server} {
+			if tcp, ok := conn.(*net.TCPConn); ok && reset {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+		}
+		close(s.done)
+	})
+}
+
+// blackhole Stops forwarding data in both directions
+func (s *session) blackhole() {
+	s.halfOpenOnce.Do(func() { close(s.halfOpen) })
+}
+
+func (s *session) isHalfOpen() bool {
+	select {
+	case <-s.halfOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+// forward Copies src into dst applying the faults of the link. The session
+// is closed once src cannot be read or dst cannot be written
+func (s *session) forward(src net.Conn, dst net.Conn, l link) {
+	defer s.close(false)
+
+	var forwarded int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		data := buf[:n]
+		if s.isHalfOpen() {
+			data = nil
+		}
+
+		if l.action != NoAction && len(data) > 0 && forwarded+int64(len(data)) >= l.after {
+			if writeErr := s.write(dst, data[:l.after-forwarded], l); writeErr != nil {
+				return
+			}
+			switch l.action {
+			case Reset:
+				s.close(true)
+				return
+			case HalfOpen:
+				s.blackhole()
+			}
+			l.action = NoAction
+			data = nil
+		}
+
+		if writeErr := s.write(dst, data, l); writeErr != nil {
+			return
+		}
+		forwarded += int64(len(data))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write Writes data to dst in fragments, waiting the latency of the link
+// first and then as long as the bandwidth of the link requires
+func (s *session) write(dst net.Conn, data []byte, l link) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if !s.wait(l.latency) {
+		return net.ErrClosed
+	}
+
+	for len(data) > 0 {
+		chunk := data
+		if l.fragment > 0 && len(chunk) > l.fragment {
+			chunk = chunk[:l.fragment]
+		}
+		if l.bandwidth > 0 && !s.wait(time.Duration(len(chunk))*time.Second/time.Duration(l.bandwidth)) {
+			return net.ErrClosed
+		}
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+	}
+	return nil
+}
+
+// wait Sleeps for the given duration. Returns false if the session is
+// closed meanwhile
+func (s *session) wait(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
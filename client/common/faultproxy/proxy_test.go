@@ -0,0 +1,153 @@
+package faultproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startEcho Starts a server that writes back everything it reads
+func startEcho(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func startProxy(t *testing.T, scenario Scenario) *Proxy {
+	t.Helper()
+	proxy, err := New(startEcho(t), scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+func TestProxyFragmentsAndDelaysTraffic(t *testing.T) {
+	proxy := startProxy(t, Scenario{Faults: []Fault{
+		{Direction: Downstream, Fragment: 3},
+		{Latency: Duration(20 * time.Millisecond)},
+	}})
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	message := []byte("BetBatchMessage|1")
+	conn.Write(message)
+	got := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, message) {
+		t.Errorf("echo = %q, want %q", got, message)
+	}
+	// Latency is added once in every direction
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("echo took %v, want at least 40ms", elapsed)
+	}
+}
+
+func TestProxyResetsSelectedConnections(t *testing.T) {
+	proxy := startProxy(t, Scenario{Faults: []Fault{
+		{Connections: []int{1}, Direction: Upstream, Action: Reset, After: 4},
+	}})
+
+	first, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte("PingMessage"))
+	got, err := io.ReadAll(first)
+	if err == nil && len(got) > 4 {
+		t.Errorf("first connection read %q after the reset", got)
+	}
+
+	second, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.Write([]byte("PingMessage"))
+	buf := make([]byte, len("PingMessage"))
+	if _, err := io.ReadFull(second, buf); err != nil {
+		t.Errorf("second connection failed: %v", err)
+	}
+	if proxy.Accepted() != 2 {
+		t.Errorf("Accepted() = %d, want 2", proxy.Accepted())
+	}
+}
+
+func TestProxyHalfOpenConnectionDropsTraffic(t *testing.T) {
+	proxy := startProxy(t, Scenario{Faults: []Fault{{Action: HalfOpen}}})
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PingMessage")); err != nil {
+		t.Fatalf("Write() = %v, want the connection to look open", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err == nil || !isTimeout(err) {
+		t.Errorf("Read() = %v, want a timeout", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	os.WriteFile(path, []byte(`{"faults": [
+		{"connections": [1], "direction": "upstream", "latency": "15ms", "fragment": 1},
+		{"action": "reset", "after": 100}
+	]}`), 0o644)
+
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := scenario.linkFor(1, Upstream)
+	want := link{latency: 15 * time.Millisecond, fragment: 1, action: Reset, after: 100}
+	if upstream != want {
+		t.Errorf("link of the first connection = %+v, want %+v", upstream, want)
+	}
+	if downstream := scenario.linkFor(2, Downstream); downstream != (link{action: Reset, after: 100}) {
+		t.Errorf("link of the second connection = %+v", downstream)
+	}
+
+	os.WriteFile(path, []byte(`{"faults": [{"action": "explode"}]}`), 0o644)
+	if _, err := LoadScenario(path); err == nil {
+		t.Error("LoadScenario() with an unknown action did not fail")
+	}
+}
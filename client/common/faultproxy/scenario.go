@@ -0,0 +1,170 @@
+package faultproxy
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Direction Traffic a fault applies to
+type Direction string
+
+const (
+	// Both Traffic in both directions
+	Both Direction = ""
+	// Upstream Traffic sent by the client to the server
+	Upstream Direction = "upstream"
+	// Downstream Traffic sent by the server to the client
+	Downstream Direction = "downstream"
+)
+
+// Action What happens to a connection once the bytes given by
+// Fault.After went through it
+type Action string
+
+const (
+	// NoAction The connection is only delayed, throttled or fragmented
+	NoAction Action = ""
+	// Reset Both ends of the connection are reset
+	Reset Action = "reset"
+	// HalfOpen The proxy silently discards everything sent afterwards in
+	// both directions while keeping the connections open, as if the peer
+	// vanished without closing them
+	HalfOpen Action = "half_open"
+)
+
+// Duration time.Duration read from a scenario as a string such as "20ms"
+type Duration time.Duration
+
+// UnmarshalJSON Parses the duration with time.ParseDuration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.Errorf("duration must be a string such as \"20ms\"")
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON Writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Fault Misbehaviour injected in some of the connections going through the
+// proxy
+type Fault struct {
+	// Connections Numbers of the connections affected, starting at 1 for
+	// the first connection accepted. Every connection when empty
+	Connections []int `json:"connections,omitempty"`
+	// Direction Traffic affected by the fault
+	Direction Direction `json:"direction,omitempty"`
+	// Latency Delay added before forwarding every chunk of data
+	Latency Duration `json:"latency,omitempty"`
+	// Bandwidth Maximum bytes per second forwarded. Unlimited when zero
+	Bandwidth int `json:"bandwidth,omitempty"`
+	// Fragment Maximum bytes forwarded by every write, which forces the
+	// peer to perform short reads. Unlimited when zero
+	Fragment int `json:"fragment,omitempty"`
+	// Action Performed once After bytes were forwarded
+	Action Action `json:"action,omitempty"`
+	// After Bytes forwarded before the action is performed
+	After int64 `json:"after,omitempty"`
+}
+
+// Scenario Faults injected by the proxy. Every fault that applies to a
+// connection is combined: latencies add up, while the lowest bandwidth,
+// fragment size and action threshold are used
+type Scenario struct {
+	Faults []Fault `json:"faults"`
+}
+
+// Validate Checks the values of every fault
+func (s Scenario) Validate() error {
+	for i, fault := range s.Faults {
+		switch {
+		case fault.Direction != Both && fault.Direction != Upstream && fault.Direction != Downstream:
+			return errors.Errorf("fault %d: unknown direction %q", i+1, fault.Direction)
+		case fault.Action != NoAction && fault.Action != Reset && fault.Action != HalfOpen:
+			return errors.Errorf("fault %d: unknown action %q", i+1, fault.Action)
+		case fault.Latency < 0 || fault.Bandwidth < 0 || fault.Fragment < 0 || fault.After < 0:
+			return errors.Errorf("fault %d: values cannot be negative", i+1)
+		}
+		for _, connection := range fault.Connections {
+			if connection < 1 {
+				return errors.Errorf("fault %d: connections are numbered from 1", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadScenario Reads a scenario from a JSON file
+func LoadScenario(path string) (Scenario, error) {
+	var scenario Scenario
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, err
+	}
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return scenario, errors.Wrapf(err, "invalid scenario %s", path)
+	}
+	return scenario, scenario.Validate()
+}
+
+// link Faults combined for one direction of a connection
+type link struct {
+	latency   time.Duration
+	bandwidth int
+	fragment  int
+	action    Action
+	after     int64
+}
+
+// linkFor Combines the faults of the scenario that apply to the given
+// connection and direction
+func (s Scenario) linkFor(connection int, direction Direction) link {
+	var l link
+	for _, fault := range s.Faults {
+		if !fault.appliesTo(connection, direction) {
+			continue
+		}
+		l.latency += time.Duration(fault.Latency)
+		l.bandwidth = lowest(l.bandwidth, fault.Bandwidth)
+		l.fragment = lowest(l.fragment, fault.Fragment)
+		if fault.Action != NoAction && (l.action == NoAction || fault.After < l.after) {
+			l.action = fault.Action
+			l.after = fault.After
+		}
+	}
+	return l
+}
+
+func (f Fault) appliesTo(connection int, direction Direction) bool {
+	if f.Direction != Both && f.Direction != direction {
+		return false
+	}
+	if len(f.Connections) == 0 {
+		return true
+	}
+	for _, c := range f.Connections {
+		if c == connection {
+			return true
+		}
+	}
+	return false
+}
+
+// lowest Lowest of two limits where zero means unlimited
+func lowest(a int, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
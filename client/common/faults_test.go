@@ -0,0 +1,155 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/faultproxy"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+// startFaultProxy Starts a proxy in front of server that is closed when
+// the test finishes
+func startFaultProxy(t *testing.T, target string, faults ...faultproxy.Fault) *faultproxy.Proxy {
+	t.Helper()
+	proxy, err := faultproxy.New(target, faultproxy.Scenario{Faults: faults})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+// manyBetsFile Writes an agency file with the given amount of bets, each
+// one with a different document
+func manyBetsFile(t *testing.T, amount int) string {
+	rows := make([]string, amount)
+	for i := range rows {
+		rows[i] = fmt.Sprintf("Nicolás,Peña,%d,1994-03-16,%d", 30000000+i, 1000+i)
+	}
+	return writeBetsFile(t, rows...)
+}
+
+// assertBetsStoredOnce Checks every bet of the file reached the server once
+func assertBetsStoredOnce(t *testing.T, server *lotterytest.Server, amount int) {
+	t.Helper()
+	seen := make(map[string]bool)
+	for _, bet := range server.Bets() {
+		if seen[bet.Document] {
+			t.Errorf("document %s stored twice", bet.Document)
+		}
+		seen[bet.Document] = true
+	}
+	if len(seen) != amount {
+		t.Errorf("server stored %d bets, want %d", len(seen), amount)
+	}
+}
+
+func TestClientToleratesSlowFragmentedConnections(t *testing.T) {
+	server := startServer(t, 1)
+	proxy := startFaultProxy(t, server.Addr, faultproxy.Fault{
+		Latency:   faultproxy.Duration(time.Millisecond),
+		Bandwidth: 64 * 1024,
+		Fragment:  1,
+	})
+
+	config := testConfig(server, manyBetsFile(t, 10))
+	config.ServerAddress = proxy.Addr
+	config.BatchWindow = 3
+	if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	assertBetsStoredOnce(t, server, 10)
+}
+
+// frameSize Size on the wire of the framed message
+func frameSize(t *testing.T, msg codec.Message) int64 {
+	t.Helper()
+	payload, err := codec.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return framing.HeaderSize + int64(len(payload))
+}
+
+func TestClientReconnectsAfterReset(t *testing.T) {
+	// The connection is reset in the middle of the header of the second
+	// ack, once the registration and the first ack went through
+	firstAck := frameSize(t, codec.RegisteredMessage{Status: codec.RegisterOK}) +
+		frameSize(t, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+
+	tests := []struct {
+		name  string
+		fault faultproxy.Fault
+	}{
+		{
+			name:  "while sending a batch",
+			fault: faultproxy.Fault{Direction: faultproxy.Upstream, Action: faultproxy.Reset, After: 200},
+		},
+		{
+			name:  "after the first ack",
+			fault: faultproxy.Fault{Direction: faultproxy.Downstream, Action: faultproxy.Reset, After: firstAck + framing.HeaderSize/2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startServer(t, 1)
+			tt.fault.Connections = []int{1}
+			proxy := startFaultProxy(t, server.Addr, tt.fault)
+
+			config := testConfig(server, manyBetsFile(t, 10))
+			config.ServerAddress = proxy.Addr
+			config.BatchWindow = 3
+			config.BatchMaxRetries = 3
+			client := NewClient(config)
+			if err := client.StartClientLoop(context.Background()); err != nil {
+				t.Fatalf("StartClientLoop() = %v", err)
+			}
+			assertBetsStoredOnce(t, server, 10)
+			if client.Metrics().Snapshot().Retries == 0 {
+				t.Error("no batch was sent again after the reset")
+			}
+		})
+	}
+}
+
+func TestClientGivesUpWhenServerIsUnreachable(t *testing.T) {
+	// The proxy accepts connections but cannot reach the server behind it,
+	// so every connection is closed as soon as it is accepted
+	server := startServer(t, 1)
+	unreachable := server.Addr
+	server.Close()
+	proxy := startFaultProxy(t, unreachable)
+
+	config := testConfig(server, manyBetsFile(t, 3))
+	config.ServerAddress = proxy.Addr
+	config.BatchMaxRetries = 2
+	if err := NewClient(config).StartClientLoop(context.Background()); err == nil {
+		t.Fatal("StartClientLoop() succeeded without a server")
+	}
+	if proxy.Accepted() != 3 {
+		t.Errorf("client opened %d connections, want 3", proxy.Accepted())
+	}
+}
+
+func TestClientStopsOnHalfOpenConnectionWhenCancelled(t *testing.T) {
+	server := startServer(t, 1)
+	proxy := startFaultProxy(t, server.Addr, faultproxy.Fault{Action: faultproxy.HalfOpen})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	config := testConfig(server, manyBetsFile(t, 3))
+	config.ServerAddress = proxy.Addr
+	if err := NewClient(config).StartClientLoop(ctx); err != context.Canceled {
+		t.Fatalf("StartClientLoop() = %v, want %v", err, context.Canceled)
+	}
+	if len(server.Bets()) != 0 {
+		t.Errorf("server stored %d bets through a half-open connection", len(server.Bets()))
+	}
+}
@@ -0,0 +1,80 @@
+// Package framing delimits protocol messages on a stream connection.
+//
+// Every frame is written as a fixed size header holding the payload length
+// followed by the payload itself:
+//
+//	[LEN_BYTES][PAYLOAD]
+//
+// Both reads and writes are performed until the whole frame is transferred,
+// so callers never observe short reads or short writes.
+package framing
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HeaderSize Amount of bytes used to encode the payload length
+	HeaderSize = 4
+	// MaxFrameSize Maximum size of a whole frame (header included). It
+	// bounds the memory a peer can make the reader allocate for one frame
+	MaxFrameSize = 8 * 1024
+	// MaxPayloadSize Maximum size of the payload carried by a frame
+	MaxPayloadSize = MaxFrameSize - HeaderSize
+)
+
+// ErrFrameTooLarge Returned when a frame exceeds MaxFrameSize, either
+// when writing it or when reading its header
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// WriteFrame Writes the payload to w preceded by its length. The write is
+// retried until every byte of the frame has been accepted by w
+func WriteFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxPayloadSize {
+		return errors.Wrapf(ErrFrameTooLarge, "payload of %d bytes", len(payload))
+	}
+
+	frame := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+
+	return writeAll(w, frame)
+}
+
+// ReadFrame Reads a whole frame from r and returns its payload. The length
+// header is checked against MaxPayloadSize before allocating any buffer
+func ReadFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxPayloadSize {
+		return nil, errors.Wrapf(ErrFrameTooLarge, "header announces %d bytes", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// writeAll Keeps writing until buf is fully written to avoid short-writes
+func writeAll(w io.Writer, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := w.Write(buf)
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
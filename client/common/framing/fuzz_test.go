@@ -0,0 +1,77 @@
+//go:build go1.18
+// +build go1.18
+
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// FuzzReadFrame Reading arbitrary bytes must never panic, and frames whose
+// header exceeds MaxPayloadSize must be rejected before reading the payload
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 4, 'P', 'i', 'n', 'g'})
+	f.Add([]byte{0, 0, 0, 9, 'P'})
+	f.Add([]byte{0, 0, 0x1f, 0xfc})
+	f.Add([]byte{0, 0, 0x1f, 0xfd})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		payload, err := ReadFrame(bytes.NewReader(data))
+		if len(data) < HeaderSize {
+			if err == nil {
+				t.Fatalf("ReadFrame(%x) read a frame without a whole header", data)
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(data)
+		switch {
+		case size > MaxPayloadSize:
+			if errors.Cause(err) != ErrFrameTooLarge {
+				t.Fatalf("ReadFrame() of a %d bytes frame = %v, want %v", size, err, ErrFrameTooLarge)
+			}
+		case int(size) > len(data)-HeaderSize:
+			if err == nil {
+				t.Fatalf("ReadFrame(%x) read a truncated frame", data)
+			}
+		case err != nil:
+			t.Fatalf("ReadFrame(%x) = %v", data, err)
+		case !bytes.Equal(payload, data[HeaderSize:HeaderSize+int(size)]):
+			t.Fatalf("ReadFrame(%x) = %x", data, payload)
+		}
+	})
+}
+
+func FuzzFrameRoundTrip(f *testing.F) {
+	f.Add([]byte("BetMessage|1|Nicolás|Peña|27726965|1994-03-16|7574"))
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{'x'}, MaxPayloadSize))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		var buf bytes.Buffer
+		err := WriteFrame(&buf, payload)
+		if len(payload) > MaxPayloadSize {
+			if errors.Cause(err) != ErrFrameTooLarge {
+				t.Fatalf("WriteFrame() of %d bytes = %v, want %v", len(payload), err, ErrFrameTooLarge)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		read, err := ReadFrame(&buf)
+		if err != nil || !bytes.Equal(read, payload) {
+			t.Fatalf("ReadFrame() = %q, %v, want %q", read, err, payload)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%d bytes left after reading the frame", buf.Len())
+		}
+	})
+}
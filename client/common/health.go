@@ -0,0 +1,123 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+const (
+	// DefaultPingTimeout Timeout used by health requests when none is given
+	DefaultPingTimeout = 3 * time.Second
+	// waitRetryPeriod Time between health requests while waiting for the
+	// server to start
+	waitRetryPeriod = 250 * time.Millisecond
+)
+
+// Ping Performs a protocol level health request against the server
+// endpoint. The server must answer within timeout, otherwise an error is
+// returned. tlsConfig is only used by tls:// endpoints. The round-trip time
+// of the request is returned on success
+func Ping(ctx context.Context, endpoint string, timeout time.Duration, tlsConfig *tls.Config) (time.Duration, error) {
+	transports := newTransports(newAddressCache(nil, DefaultResolveTTL), &net.Dialer{}, tlsConfig)
+	return ping(ctx, clock.System, transports, endpoint, timeout)
+}
+
+// ping Measures the round-trip time of the health request with clk. The
+// timeout is a network deadline, so it always runs on the system clock
+func ping(ctx context.Context, clk clock.Clock, transports map[string]Transport, endpoint string, timeout time.Duration) (time.Duration, error) {
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := clk.Now()
+	conn, err := dialEndpoint(ctx, transports, endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	payload, err := codec.Encode(codec.PingMessage{})
+	if err != nil {
+		return 0, err
+	}
+	if err := framing.WriteFrame(conn, payload); err != nil {
+		return 0, err
+	}
+
+	reply, err := framing.ReadFrame(conn)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := codec.Decode(reply)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := msg.(codec.PongMessage); !ok {
+		return 0, &UnexpectedMessageError{Got: msg.Type(), Want: codec.TypePong}
+	}
+	return clk.Now().Sub(start), nil
+}
+
+// waitForServer Pings the server endpoints until one of them answers or
+// the configured wait timeout expires
+func (c *Client) waitForServer(ctx context.Context) error {
+	// The wait timeout runs on the client clock, so it cancels ctx itself
+	// instead of relying on a context deadline
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	deadline := c.clock.NewTimer(c.config.WaitTimeout)
+	defer deadline.Stop()
+	expired := make(chan struct{})
+	go func() {
+		select {
+		case <-deadline.C():
+			close(expired)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		address := c.endpoints.pick()
+		_, err := ping(ctx, c.clock, c.transports, address, c.config.PingTimeout)
+		if err == nil {
+			c.endpoints.success(address)
+			c.log.Infof("action: wait_for_server | result: success | client_id: %v | server_address: %v", c.config.ID, address)
+			return nil
+		}
+		if ctx.Err() == nil {
+			c.endpoints.failure(address)
+		}
+		c.log.Debugf("action: wait_for_server | result: in_progress | client_id: %v | server_address: %v | error: %v",
+			c.config.ID,
+			address,
+			err,
+		)
+
+		if err := c.sleep(ctx, waitRetryPeriod); err != nil {
+			select {
+			case <-expired:
+				err = context.DeadlineExceeded
+			default:
+			}
+			c.log.Errorf("action: wait_for_server | result: fail | client_id: %v | error: server did not answer within %v",
+				c.config.ID,
+				c.config.WaitTimeout,
+			)
+			return err
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingAgainstFakeServer(t *testing.T) {
+	server := startServer(t, 1)
+
+	if _, err := Ping(context.Background(), server.Addr, time.Second, nil); err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+}
+
+func TestPingTimesOutWhenServerDoesNotAnswer(t *testing.T) {
+	// Connections are accepted by the kernel but nothing is ever answered
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	start := time.Now()
+	if _, err := Ping(context.Background(), listener.Addr().String(), 50*time.Millisecond, nil); err == nil {
+		t.Fatal("Ping() succeeded against a server that does not answer")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Ping() took %v, want it to respect the timeout", elapsed)
+	}
+}
+
+func TestWaitForServerGivesUpAfterTimeout(t *testing.T) {
+	// Reserve a port and release it so nobody is listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		PingTimeout:   50 * time.Millisecond,
+		WaitTimeout:   300 * time.Millisecond,
+	})
+	if err := client.waitForServer(context.Background()); err != context.DeadlineExceeded {
+		t.Fatalf("waitForServer() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
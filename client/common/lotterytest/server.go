@@ -0,0 +1,562 @@
+// Package lotterytest provides an in-process lottery server listening on the
+// loopback interface, so clients can be exercised through real sockets in
+// tests.
+package lotterytest
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/draw"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// LotteryWinnerNumber Simulated winner number in the lottery contest
+const LotteryWinnerNumber = "7574"
+
+// Config Draw coordinated by a server started with NewServerConfig
+type Config struct {
+	// Agencies Amount of agencies the draw waits for when there is no
+	// roster
+	Agencies int
+	// Roster Agencies expected to take part in the draw, which waits for
+	// all of them. Other agencies are refused when they register. When
+	// empty any agency may register
+	Roster []string
+	// DrawDeadline Time the draw waits once the first agency registered.
+	// When it elapses the draw takes place with the agencies that finished
+	// sending their bets by then. There is no deadline when zero
+	DrawDeadline time.Duration
+	// CommitReveal Picks the winning number of every round from a random
+	// seed the server commits to when agencies register, instead of using
+	// LotteryWinnerNumber
+	CommitReveal bool
+}
+
+// Server Fake central server. It hosts successive rounds, each with its
+// own draw and winning number: it stores every bet received, performs the draw of a round
+// once the expected agencies finished sending their bets for it and
+// answers the winners of each agency in the round afterwards. Rounds no
+// agency took part in are answered as unknown. Connections
+// that notified the end of the bets are told when the draw takes place
+type Server struct {
+	// Addr Address the server listens on, in the form host:port
+	Addr string
+
+	agencies     int
+	roster       map[string]bool
+	deadline     time.Duration
+	commitReveal bool
+	wg           sync.WaitGroup
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	rounds    map[uint64]*round
+	conns     map[net.Conn]bool
+	answer    func(codec.BetBatchMessage) codec.AckStatus
+	closed    bool
+	noPush    bool
+}
+
+// round Bets and draw of a single round
+type round struct {
+	bets     []codec.BetMessage
+	stored   map[batchKey]bool
+	finished map[string]bool
+	// subscribers Connections waiting for the draw to be pushed
+	subscribers map[*serverConn]bool
+	// participants Agencies whose bets took part in the draw, nil until it
+	// takes place
+	participants map[string]bool
+	registered   map[string]bool
+	drawTimer    *time.Timer
+	// seed Seed the winning number derives from, empty unless the server
+	// commits to it
+	seed   string
+	number string
+}
+
+// serverConn Connection whose frames may be written by its handler and by
+// the draw push at the same time
+type serverConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *serverConn) write(msg codec.Message) error {
+	encoded, err := codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return framing.WriteFrame(c.Conn, encoded)
+}
+
+// batchKey Identifies a batch among the ones sent by every agency
+type batchKey struct {
+	agency string
+	id     uint64
+}
+
+// NewServer Starts a server on a random loopback port that performs the
+// draw after the given amount of agencies notify the end of their bets
+func NewServer(agencies int) (*Server, error) {
+	return NewServerConfig(Config{Agencies: agencies})
+}
+
+// NewServerConfig Starts a server on a random loopback port that
+// coordinates the draw described by config
+func NewServerConfig(config Config) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Addr:         listener.Addr().String(),
+		agencies:     config.Agencies,
+		deadline:     config.DrawDeadline,
+		commitReveal: config.CommitReveal,
+		rounds:       make(map[uint64]*round),
+		conns:        make(map[net.Conn]bool),
+	}
+	if len(config.Roster) > 0 {
+		s.roster = make(map[string]bool)
+		for _, agency := range config.Roster {
+			s.roster[agency] = true
+		}
+	}
+	s.Serve(listener)
+	return s, nil
+}
+
+// Serve Accepts connections from another listener too, such as a Unix
+// socket or a TLS listener. The listener is closed along with the server
+func (s *Server) Serve(listener net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		listener.Close()
+		return
+	}
+	s.listeners = append(s.listeners, listener)
+	s.wg.Add(1)
+	go s.acceptLoop(listener)
+}
+
+// ServeConn Attends a connection opened by other means, such as the server
+// end of a net.Pipe. It returns once the connection is closed
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	s.handleConnection(conn)
+}
+
+// AnswerBatches Makes the server acknowledge every batch with the status
+// returned by answer. Only the bets of batches answered with codec.AckOK
+// are stored
+func (s *Server) AnswerBatches(answer func(codec.BetBatchMessage) codec.AckStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answer = answer
+}
+
+// PushDraw Sets whether the server pushes the draw to the agencies or only
+// answers their winners requests, like servers that predate the push
+func (s *Server) PushDraw(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noPush = !enabled
+}
+
+// Bets Returns every bet stored so far, ordered by round
+func (s *Server) Bets() []codec.BetMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []uint64
+	for id := range s.rounds {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var bets []codec.BetMessage
+	for _, id := range ids {
+		bets = append(bets, s.rounds[id].bets...)
+	}
+	return bets
+}
+
+// RoundBets Returns the bets stored so far for the round
+func (s *Server) RoundBets(id uint64) []codec.BetMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.rounds[id]; ok {
+		return append([]codec.BetMessage(nil), r.bets...)
+	}
+	return nil
+}
+
+// Close Stops accepting connections, closes the open ones and waits for
+// every handler to return
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for _, r := range s.rounds {
+		if r.drawTimer != nil {
+			r.drawTimer.Stop()
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	var err error
+	for _, listener := range s.listeners {
+		if closeErr := listener.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection Answers every message of the connection until the
+// client closes it or sends something that cannot be parsed
+func (s *Server) handleConnection(conn net.Conn) {
+	sc := &serverConn{Conn: conn}
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		for _, r := range s.rounds {
+			delete(r.subscribers, sc)
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		payload, err := framing.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+
+		reply, drawn, err := s.handleMessage(sc, payload)
+		if err != nil {
+			return
+		}
+		if drawn != nil {
+			s.pushDraw(drawn)
+		}
+		if reply == nil {
+			continue
+		}
+		if err := sc.write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// pushDraw Tells the subscribed connections the draw took place. Failing
+// to reach one of them only means its agency will ask for the winners
+func (s *Server) pushDraw(subscribers []*serverConn) {
+	for _, sc := range subscribers {
+		sc.write(codec.DrawCompleteMessage{})
+	}
+}
+
+// WinningNumber Number the bets of the round must hold to win. The round
+// starts if nobody took part in it yet
+func (s *Server) WinningNumber(id uint64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.round(id)
+	if err != nil {
+		return "", err
+	}
+	return r.number, nil
+}
+
+// Registered Agencies that registered so far for the round
+func (s *Server) Registered(id uint64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var agencies []string
+	r, ok := s.rounds[id]
+	if !ok {
+		return nil
+	}
+	for agency := range r.registered {
+		agencies = append(agencies, agency)
+	}
+	sort.Strings(agencies)
+	return agencies
+}
+
+// round Returns the state of the round, which starts the first time an
+// agency registers, sends bets or finishes for it. Fails when the seed of
+// a new round cannot be picked
+func (s *Server) round(id uint64) (*round, error) {
+	r, ok := s.rounds[id]
+	if !ok {
+		r = &round{
+			stored:      make(map[batchKey]bool),
+			finished:    make(map[string]bool),
+			subscribers: make(map[*serverConn]bool),
+			registered:  make(map[string]bool),
+			number:      LotteryWinnerNumber,
+		}
+		if s.commitReveal {
+			seed, err := draw.NewSeed()
+			if err != nil {
+				return nil, err
+			}
+			r.seed, r.number = seed, draw.WinningNumber(seed)
+		}
+		s.rounds[id] = r
+	}
+	return r, nil
+}
+
+// register Registers the agency for the round unless it is not expected
+// or the draw already took place without it. The draw deadline starts
+// with the first registration
+func (s *Server) register(id uint64, agency string) (codec.RegisterStatus, error) {
+	if !s.expected(agency) {
+		return codec.RegisterUnknown, nil
+	}
+	r, err := s.round(id)
+	if err != nil {
+		return 0, err
+	}
+	if r.participants != nil {
+		if r.participants[agency] {
+			return codec.RegisterOK, nil
+		}
+		return codec.RegisterClosed, nil
+	}
+
+	r.registered[agency] = true
+	if s.deadline > 0 && r.drawTimer == nil && !s.closed {
+		r.drawTimer = time.AfterFunc(s.deadline, func() { s.deadlineExpired(id) })
+	}
+	return codec.RegisterOK, nil
+}
+
+// deadlineExpired Performs the draw of the round with the agencies that
+// finished so far
+func (s *Server) deadlineExpired(id uint64) {
+	s.mu.Lock()
+	r := s.rounds[id]
+	if r.participants != nil {
+		s.mu.Unlock()
+		return
+	}
+	subscribers := r.draw()
+	s.mu.Unlock()
+	s.pushDraw(subscribers)
+}
+
+// draw Takes the agencies that finished sending their bets as the ones
+// taking part in the draw and returns the connections to push it to
+func (r *round) draw() []*serverConn {
+	r.participants = make(map[string]bool)
+	for agency := range r.finished {
+		r.participants[agency] = true
+	}
+	if r.drawTimer != nil {
+		r.drawTimer.Stop()
+	}
+
+	var subscribers []*serverConn
+	for subscriber := range r.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	r.subscribers = make(map[*serverConn]bool)
+	return subscribers
+}
+
+// expected Whether the agency may take part in the draws
+func (s *Server) expected(agency string) bool {
+	return s.roster == nil || s.roster[agency]
+}
+
+// allFinished Whether every expected agency finished sending its bets for
+// the round
+func (s *Server) allFinished(r *round) bool {
+	if s.roster != nil {
+		for agency := range s.roster {
+			if !r.finished[agency] {
+				return false
+			}
+		}
+		return true
+	}
+	return len(r.finished) >= s.agencies
+}
+
+// handleMessage Processes a message and returns the reply, if any. The
+// connections to push the draw to are returned once it takes place. Fails
+// when a round cannot be started, which closes the connection
+func (s *Server) handleMessage(sc *serverConn, payload []byte) (codec.Message, []*serverConn, error) {
+	msg, err := codec.Decode(payload)
+	if err != nil {
+		return codec.AckMessage{Status: codec.AckRejected}, nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m := msg.(type) {
+	case codec.RegisterMessage:
+		status, err := s.register(m.Round, m.Agency)
+		if err != nil {
+			return nil, nil, err
+		}
+		reply := codec.RegisteredMessage{Status: status}
+		if r := s.rounds[m.Round]; status == codec.RegisterOK && r.seed != "" {
+			reply.Commitment = draw.Commitment(r.seed)
+		}
+		return reply, nil, nil
+	case codec.BetBatchMessage:
+		status, err := s.storeBatch(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		return codec.AckMessage{BatchID: m.ID, Status: status}, nil, nil
+	case codec.EndOfBetsMessage:
+		if !s.expected(m.Agency) {
+			return nil, nil, nil
+		}
+		r, err := s.round(m.Round)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r.participants != nil {
+			if s.noPush {
+				return nil, nil, nil
+			}
+			if !r.participants[m.Agency] {
+				return codec.DrawMissedMessage{}, nil, nil
+			}
+			return nil, []*serverConn{sc}, nil
+		}
+		r.finished[m.Agency] = true
+		if !s.noPush {
+			r.subscribers[sc] = true
+		}
+		if !s.allFinished(r) {
+			return nil, nil, nil
+		}
+		return nil, r.draw(), nil
+	case codec.WinnersRequestMessage:
+		// Asking about a round does not start it, so rounds nobody took
+		// part in are refused instead of staying pending forever
+		r, ok := s.rounds[m.Round]
+		if !ok {
+			return codec.UnknownRoundMessage{}, nil, nil
+		}
+		if r.participants == nil {
+			return codec.WinnersPendingMessage{}, nil, nil
+		}
+		if !r.participants[m.Agency] {
+			return codec.DrawMissedMessage{}, nil, nil
+		}
+		return codec.WinnersNotificationMessage{Documents: r.winners(m.Agency)}, nil, nil
+	case codec.RevealRequestMessage:
+		if !s.commitReveal {
+			break
+		}
+		r, ok := s.rounds[m.Round]
+		if !ok {
+			return codec.UnknownRoundMessage{}, nil, nil
+		}
+		if r.participants == nil {
+			return codec.WinnersPendingMessage{}, nil, nil
+		}
+		return codec.RevealMessage{Number: r.number, Seed: r.seed}, nil, nil
+	case codec.PingMessage:
+		return codec.PongMessage{}, nil, nil
+	}
+	return codec.AckMessage{Status: codec.AckRejected}, nil, nil
+}
+
+// storeBatch Stores the bets of the batch in its round unless it was
+// stored before, which happens when the client retransmits a batch whose
+// ack was lost
+func (s *Server) storeBatch(batch codec.BetBatchMessage) (codec.AckStatus, error) {
+	if s.answer != nil {
+		if status := s.answer(batch); status != codec.AckOK {
+			return status, nil
+		}
+	}
+	if len(batch.Bets) == 0 {
+		return codec.AckOK, nil
+	}
+	// Bets that arrive once the draw took place without their agency,
+	// or from unexpected agencies, would never take part in it
+	agency := batch.Bets[0].Agency
+	if !s.expected(agency) {
+		return codec.AckRejected, nil
+	}
+	r, err := s.round(batch.Round)
+	if err != nil {
+		return 0, err
+	}
+	if r.participants != nil && !r.participants[agency] {
+		return codec.AckRejected, nil
+	}
+
+	key := batchKey{agency: agency, id: batch.ID}
+	if !r.stored[key] {
+		r.stored[key] = true
+		r.bets = append(r.bets, batch.Bets...)
+	}
+	return codec.AckOK, nil
+}
+
+// winners Documents of the winning bets placed in the agency for the round
+func (r *round) winners(agency string) []string {
+	var documents []string
+	for _, bet := range r.bets {
+		if bet.Agency == agency && bet.Number == r.number {
+			documents = append(documents, bet.Document)
+		}
+	}
+	return documents
+}
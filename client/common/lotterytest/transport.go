@@ -0,0 +1,86 @@
+package lotterytest
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// Transport In-memory transport whose connections are attended step by
+// step by the test instead of by a server. Every connection is a net.Pipe,
+// so each dial, send and receive of the client waits for the test to take
+// its part of the exchange and the whole conversation happens in the order
+// the test dictates, without sockets nor timing assumptions
+type Transport struct {
+	conns chan *Conn
+}
+
+// NewTransport Returns a transport with no connections
+func NewTransport() *Transport {
+	return &Transport{conns: make(chan *Conn)}
+}
+
+// Dial Blocks until the test accepts the connection or ctx is cancelled
+func (t *Transport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case t.conns <- &Conn{Conn: server, Address: address}:
+		return client, nil
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Accept Returns the next connection dialed by the client
+func (t *Transport) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case conn := <-t.conns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Conn Server end of a connection dialed through a Transport
+type Conn struct {
+	net.Conn
+	// Address Address the client dialed
+	Address string
+}
+
+// Receive Reads the next message sent by the client
+func (c *Conn) Receive() (codec.Message, error) {
+	payload, err := framing.ReadFrame(c)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(payload)
+}
+
+// Send Writes a message to the client
+func (c *Conn) Send(msg codec.Message) error {
+	payload, err := codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	return framing.WriteFrame(c, payload)
+}
+
+// ReceiveBatch Reads the next message and fails unless it is a batch
+func (c *Conn) ReceiveBatch() (codec.BetBatchMessage, error) {
+	msg, err := c.Receive()
+	if err != nil {
+		return codec.BetBatchMessage{}, err
+	}
+	batch, ok := msg.(codec.BetBatchMessage)
+	if !ok {
+		return codec.BetBatchMessage{}, errors.Errorf("received %s instead of a batch", msg.Type())
+	}
+	return batch, nil
+}
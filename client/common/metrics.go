@@ -0,0 +1,310 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionState State of the connection between a client and the server
+type ConnectionState int32
+
+const (
+	Disconnected ConnectionState = iota
+	Connecting
+	Connected
+)
+
+var connectionStates = []ConnectionState{Disconnected, Connecting, Connected}
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(s))
+}
+
+// rttBuckets Upper bounds in seconds of the round-trip latency histogram
+var rttBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics Counters describing the communication of a client with the
+// server. Every method is safe for concurrent use
+type Metrics struct {
+	betsSent      int64
+	batchesAcked  int64
+	batchesFailed int64
+	retries       int64
+	bytesWritten  int64
+	bytesRead     int64
+	state         int32
+	breakerState  int32
+	breakerOpens  int64
+
+	mu           sync.Mutex
+	rttBuckets   []uint64
+	rttSum       time.Duration
+	rttCount     uint64
+	rttObservers []func(time.Duration)
+	endpoints    map[string]bool
+}
+
+// MetricsSnapshot Values of the metrics at a given moment
+type MetricsSnapshot struct {
+	BetsSent      int64
+	BatchesAcked  int64
+	BatchesFailed int64
+	Retries       int64
+	BytesWritten  int64
+	BytesRead     int64
+	State         ConnectionState
+	BreakerState  BreakerState
+	BreakerOpens  int64
+	RTTCount      uint64
+	RTTSum        time.Duration
+}
+
+// NewMetrics Initializes every metric at zero
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rttBuckets: make([]uint64, len(rttBuckets)),
+		endpoints:  make(map[string]bool),
+	}
+}
+
+// BetsSent Adds n bets to the amount of bets written to the server
+func (m *Metrics) BetsSent(n int) {
+	atomic.AddInt64(&m.betsSent, int64(n))
+}
+
+// BatchAcked Counts a batch confirmed by the server
+func (m *Metrics) BatchAcked() {
+	atomic.AddInt64(&m.batchesAcked, 1)
+}
+
+// BatchFailed Counts a batch rejected by the server or lost on the way
+func (m *Metrics) BatchFailed() {
+	atomic.AddInt64(&m.batchesFailed, 1)
+}
+
+// Retry Counts a batch sent again after a failure
+func (m *Metrics) Retry() {
+	atomic.AddInt64(&m.retries, 1)
+}
+
+// BytesWritten Adds n bytes to the amount written to the connection
+func (m *Metrics) BytesWritten(n int) {
+	atomic.AddInt64(&m.bytesWritten, int64(n))
+}
+
+// BytesRead Adds n bytes to the amount read from the connection
+func (m *Metrics) BytesRead(n int) {
+	atomic.AddInt64(&m.bytesRead, int64(n))
+}
+
+// SetConnectionState Updates the current state of the connection
+func (m *Metrics) SetConnectionState(state ConnectionState) {
+	atomic.StoreInt32(&m.state, int32(state))
+}
+
+// SetBreakerState Updates the state of the circuit breaker, counting the
+// times it opens
+func (m *Metrics) SetBreakerState(state BreakerState) {
+	atomic.StoreInt32(&m.breakerState, int32(state))
+	if state == BreakerOpen {
+		atomic.AddInt64(&m.breakerOpens, 1)
+	}
+}
+
+// SetEndpointHealth Updates whether the server endpoint is healthy
+func (m *Metrics) SetEndpointHealth(address string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.endpoints[address] = healthy
+}
+
+// ObserveRTT Records the time elapsed between sending a request and
+// receiving its reply
+func (m *Metrics) ObserveRTT(rtt time.Duration) {
+	m.mu.Lock()
+	for i, bound := range rttBuckets {
+		if rtt.Seconds() <= bound {
+			m.rttBuckets[i]++
+		}
+	}
+	m.rttSum += rtt
+	m.rttCount++
+	observers := m.rttObservers
+	m.mu.Unlock()
+
+	for _, observe := range observers {
+		observe(rtt)
+	}
+}
+
+// OnRTT Registers f to be called with every round-trip time observed, for
+// callers that need more detail than the histogram buckets
+func (m *Metrics) OnRTT(f func(rtt time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rttObservers = append(m.rttObservers, f)
+}
+
+// Snapshot Returns the current value of every metric
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	rttSum, rttCount := m.rttSum, m.rttCount
+	m.mu.Unlock()
+
+	return MetricsSnapshot{
+		BetsSent:      atomic.LoadInt64(&m.betsSent),
+		BatchesAcked:  atomic.LoadInt64(&m.batchesAcked),
+		BatchesFailed: atomic.LoadInt64(&m.batchesFailed),
+		Retries:       atomic.LoadInt64(&m.retries),
+		BytesWritten:  atomic.LoadInt64(&m.bytesWritten),
+		BytesRead:     atomic.LoadInt64(&m.bytesRead),
+		State:         ConnectionState(atomic.LoadInt32(&m.state)),
+		BreakerState:  BreakerState(atomic.LoadInt32(&m.breakerState)),
+		BreakerOpens:  atomic.LoadInt64(&m.breakerOpens),
+		RTTCount:      rttCount,
+		RTTSum:        rttSum,
+	}
+}
+
+// ServeHTTP Writes every metric using the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo Writes every metric to w using the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	snapshot := m.Snapshot()
+	// Buckets are copied along with the totals so the histogram is consistent
+	m.mu.Lock()
+	buckets := append([]uint64(nil), m.rttBuckets...)
+	snapshot.RTTSum, snapshot.RTTCount = m.rttSum, m.rttCount
+	endpoints := make([]string, 0, len(m.endpoints))
+	for address := range m.endpoints {
+		endpoints = append(endpoints, address)
+	}
+	sort.Strings(endpoints)
+	healthy := make([]int, len(endpoints))
+	for i, address := range endpoints {
+		if m.endpoints[address] {
+			healthy[i] = 1
+		}
+	}
+	m.mu.Unlock()
+
+	p := &metricsPrinter{w: w}
+	p.counter("lottery_client_bets_sent_total", "Bets written to the server.", snapshot.BetsSent)
+	p.counter("lottery_client_batches_acked_total", "Batches confirmed by the server.", snapshot.BatchesAcked)
+	p.counter("lottery_client_batches_failed_total", "Batches rejected by the server or lost on the way.", snapshot.BatchesFailed)
+	p.counter("lottery_client_retries_total", "Batches sent again after a failure.", snapshot.Retries)
+	p.counter("lottery_client_bytes_written_total", "Bytes written to the server connection.", snapshot.BytesWritten)
+	p.counter("lottery_client_bytes_read_total", "Bytes read from the server connection.", snapshot.BytesRead)
+
+	p.header("lottery_client_request_rtt_seconds", "Round-trip latency of requests to the server.", "histogram")
+	for i, bound := range rttBuckets {
+		p.printf("lottery_client_request_rtt_seconds_bucket{le=\"%g\"} %d\n", bound, buckets[i])
+	}
+	p.printf("lottery_client_request_rtt_seconds_bucket{le=\"+Inf\"} %d\n", snapshot.RTTCount)
+	p.printf("lottery_client_request_rtt_seconds_sum %g\n", snapshot.RTTSum.Seconds())
+	p.printf("lottery_client_request_rtt_seconds_count %d\n", snapshot.RTTCount)
+
+	p.header("lottery_client_connection_state", "Current state of the server connection.", "gauge")
+	for _, state := range connectionStates {
+		value := 0
+		if state == snapshot.State {
+			value = 1
+		}
+		p.printf("lottery_client_connection_state{state=\"%v\"} %d\n", state, value)
+	}
+
+	p.counter("lottery_client_circuit_breaker_opens_total", "Times the circuit breaker opened.", snapshot.BreakerOpens)
+	p.header("lottery_client_circuit_breaker_state", "Current state of the circuit breaker.", "gauge")
+	for _, state := range breakerStates {
+		value := 0
+		if state == snapshot.BreakerState {
+			value = 1
+		}
+		p.printf("lottery_client_circuit_breaker_state{state=\"%v\"} %d\n", state, value)
+	}
+
+	if len(endpoints) > 0 {
+		p.header("lottery_client_endpoint_healthy", "Whether the last connection to the server endpoint succeeded.", "gauge")
+		for i, address := range endpoints {
+			p.printf("lottery_client_endpoint_healthy{address=\"%s\"} %d\n", address, healthy[i])
+		}
+	}
+	return p.written, p.err
+}
+
+// metricsPrinter Writes metric lines keeping the first error found
+type metricsPrinter struct {
+	w       io.Writer
+	written int64
+	err     error
+}
+
+func (p *metricsPrinter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.written += int64(n)
+	p.err = err
+}
+
+func (p *metricsPrinter) header(name string, help string, kind string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *metricsPrinter) counter(name string, help string, value int64) {
+	p.header(name, help, "counter")
+	p.printf("%s %d\n", name, value)
+}
+
+// startMetricsServer Serves the metrics on the /metrics path of address
+func startMetricsServer(address string, metrics *Metrics, log Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	log.Infof("action: metrics_server | result: success | address: %v", listener.Addr())
+	return server, nil
+}
+
+// meteredConn Connection that accounts every byte read and written
+type meteredConn struct {
+	net.Conn
+	metrics *Metrics
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.metrics.BytesRead(n)
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.metrics.BytesWritten(n)
+	return n, err
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+)
+
+func TestMetricsAgainstFakeServer(t *testing.T) {
+	server := startServer(t, 1)
+	// Reject the batch holding the last bet
+	server.AnswerBatches(func(batch codec.BetBatchMessage) codec.AckStatus {
+		if batch.Bets[0].Document == "29369913" {
+			return codec.AckRejected
+		}
+		return codec.AckOK
+	})
+	betsFile := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,1987-08-01,8676",
+		"Martina Pilar,Mamani,29369913,1989-12-05,6857",
+	)
+
+	client := NewClient(testConfig(server, betsFile))
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+
+	snapshot := client.Metrics().Snapshot()
+	if snapshot.BetsSent != 3 || snapshot.BatchesAcked != 1 || snapshot.BatchesFailed != 1 {
+		t.Errorf("bets sent %d, batches acked %d, batches failed %d, want 3, 1, 1",
+			snapshot.BetsSent, snapshot.BatchesAcked, snapshot.BatchesFailed)
+	}
+	if snapshot.BytesWritten == 0 || snapshot.BytesRead == 0 {
+		t.Errorf("bytes written %d, bytes read %d, want both above zero",
+			snapshot.BytesWritten, snapshot.BytesRead)
+	}
+	// Two batches and at least one winners request
+	if snapshot.RTTCount < 3 {
+		t.Errorf("RTT observations = %d, want at least 3", snapshot.RTTCount)
+	}
+	if snapshot.State != Disconnected {
+		t.Errorf("connection state = %v, want %v", snapshot.State, Disconnected)
+	}
+
+	recorder := httptest.NewRecorder()
+	client.Metrics().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE lottery_client_bets_sent_total counter",
+		"lottery_client_bets_sent_total 3",
+		"lottery_client_batches_acked_total 1",
+		"lottery_client_batches_failed_total 1",
+		"lottery_client_retries_total 0",
+		fmt.Sprintf("lottery_client_request_rtt_seconds_count %d", snapshot.RTTCount),
+		fmt.Sprintf(`lottery_client_request_rtt_seconds_bucket{le="+Inf"} %d`, snapshot.RTTCount),
+		`lottery_client_connection_state{state="disconnected"} 1`,
+		`lottery_client_connection_state{state="connected"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics output is missing %q:\n%s", line, body)
+		}
+	}
+}
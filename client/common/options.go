@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"net"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+)
+
+// Option Customizes a client built by NewClient
+type Option func(*Client)
+
+// Logger Receives the log lines of the client. It is satisfied by the
+// *logging.Logger of go-logging, which is used when no logger is given
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Criticalf(format string, args ...interface{})
+}
+
+// defaultLogger Logger of the clients built without WithLogger. Its output
+// is configured by the program through the go-logging backend
+var defaultLogger Logger = logging.MustGetLogger("log")
+
+// Dialer Opens the network connections of the TCP, TLS and Unix
+// transports. It is satisfied by *net.Dialer
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// BetSource Provides the bets uploaded by the client. Read returns io.EOF
+// once there are no bets left. A *csv.ParseError only skips the bet, any
+// other error stops the upload. It is satisfied by *BetReader
+type BetSource interface {
+	Read() (Bet, error)
+}
+
+// ResultSink Receives the winning documents of the agency once the server
+// announced them
+type ResultSink interface {
+	Winners(ctx context.Context, documents []string) error
+}
+
+// WithLogger Makes the client write its logs to logger instead of the
+// go-logging logger configured by the program
+func WithLogger(logger Logger) Option {
+	return func(c *Client) {
+		c.log = logger
+	}
+}
+
+// WithDialer Makes the default transports open their connections through
+// dialer
+func WithDialer(dialer Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// WithClock Makes the client read the time and wait through clk. Network
+// deadlines are the only ones still measured by the system clock
+func WithClock(clk clock.Clock) Option {
+	return func(c *Client) {
+		c.clock = clk
+	}
+}
+
+// WithMetrics Makes the client update metrics instead of a set of its own,
+// so several clients can share it or the program can expose it elsewhere
+func WithMetrics(metrics *Metrics) Option {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// WithBetSource Makes the client upload the bets of source instead of
+// reading the configured bets file. The caller keeps ownership of source
+func WithBetSource(source BetSource) Option {
+	return func(c *Client) {
+		c.source = source
+	}
+}
+
+// WithResultSink Hands the winners of the agency to sink instead of
+// exporting them to the configured winners output
+func WithResultSink(sink ResultSink) Option {
+	return func(c *Client) {
+		c.results = sink
+	}
+}
+
+// WithTransport Makes the client open the connections of the endpoints
+// with the given scheme through transport, replacing the default
+// transport of the scheme if there is one
+func WithTransport(scheme string, transport Transport) Option {
+	return func(c *Client) {
+		c.transports[scheme] = transport
+	}
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger Logger that keeps every line logged
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{})    { l.record(format, args...) }
+func (l *recordingLogger) Infof(format string, args ...interface{})     { l.record(format, args...) }
+func (l *recordingLogger) Warningf(format string, args ...interface{})  { l.record(format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...interface{})    { l.record(format, args...) }
+func (l *recordingLogger) Criticalf(format string, args ...interface{}) { l.record(format, args...) }
+
+func (l *recordingLogger) contains(action string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, "action: "+action+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// sliceSource Bet source that returns the bets of a slice
+type sliceSource []Bet
+
+func (s *sliceSource) Read() (Bet, error) {
+	if len(*s) == 0 {
+		return Bet{}, io.EOF
+	}
+	bet := (*s)[0]
+	*s = (*s)[1:]
+	return bet, nil
+}
+
+// sinkFunc Result sink that calls the function
+type sinkFunc func(ctx context.Context, documents []string) error
+
+func (f sinkFunc) Winners(ctx context.Context, documents []string) error {
+	return f(ctx, documents)
+}
+
+// countingDialer Dialer that counts the connections it opens
+type countingDialer struct {
+	net.Dialer
+	mu    sync.Mutex
+	dials int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials++
+	d.mu.Unlock()
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestClientOptionsReplaceCollaborators(t *testing.T) {
+	server := startServer(t, 1)
+	config := testConfig(server, "")
+	config.WinnersOutput = "never-written.csv"
+
+	logger := &recordingLogger{}
+	dialer := &countingDialer{}
+	metrics := NewMetrics()
+	source := &sliceSource{
+		{Agency: "1", FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"},
+		{Agency: "1", FirstName: "Maria Antonella", LastName: "Leiva", Document: "24260718", Birthdate: "1987-08-01", Number: "8676"},
+		{Agency: "1", FirstName: "Nicolás", LastName: "Peña", Document: "27726965", Birthdate: "1994-03-16", Number: "7574"},
+	}
+	var winners []string
+
+	client := NewClient(config,
+		WithLogger(logger),
+		WithDialer(dialer),
+		WithMetrics(metrics),
+		WithBetSource(source),
+		WithResultSink(sinkFunc(func(ctx context.Context, documents []string) error {
+			winners = documents
+			return nil
+		})),
+	)
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+
+	if got := len(server.Bets()); got != 3 {
+		t.Errorf("server stored %d bets, want 3", got)
+	}
+	if want := []string{"30904465", "27726965"}; !reflect.DeepEqual(winners, want) {
+		t.Errorf("sink received %v, want %v", winners, want)
+	}
+	if !logger.contains("loop_finished") || !logger.contains("export_winners") {
+		t.Errorf("logger did not receive the client logs: %q", logger.lines)
+	}
+	if dialer.dials == 0 {
+		t.Error("the connections were not opened through the dialer")
+	}
+	if client.Metrics() != metrics || metrics.Snapshot().BetsSent != 3 {
+		t.Errorf("given metrics were not updated: %+v", metrics.Snapshot())
+	}
+}
@@ -0,0 +1,507 @@
+package common
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// inflightBatch Batch handed to the pipeline along with its delivery state
+type inflightBatch struct {
+	batch    codec.BetBatchMessage
+	sentAt   time.Time
+	attempts int
+	size     int
+}
+
+// reply Message or error read from the server connection
+type reply struct {
+	msg codec.Message
+	err error
+}
+
+// pipeline Sends batches over a persistent connection keeping up to window
+// of them unacknowledged at the same time. Acks are matched by batch ID.
+// Batches the server could not process are sent again in ID order before
+// any new batch, and the window is halved every time the server signals
+// backpressure. It grows back by one batch with every successful ack.
+// Sends are paced by LoopPeriod and, when configured, by token buckets of
+// bets and bytes per second
+type pipeline struct {
+	client     *Client
+	builder    *batchBuilder
+	maxWindow  int
+	window     int
+	maxRetries int
+	betsRate   *rateLimiter
+	bytesRate  *rateLimiter
+
+	nextID     uint64
+	fresh      *inflightBatch
+	exhausted  bool
+	rejected   int
+	inflight   map[uint64]*inflightBatch
+	retransmit []*inflightBatch
+
+	replies    chan reply
+	stopReader chan struct{}
+	stopWatch  func()
+}
+
+func newPipeline(client *Client) *pipeline {
+	config := client.config
+	window := config.BatchWindow
+	if window < 1 {
+		window = 1
+	}
+	// By default the buckets hold a whole batch
+	betsBurst := config.BetsBurst
+	if betsBurst <= 0 {
+		betsBurst = config.BatchMaxAmount
+	}
+	bytesBurst := config.BytesBurst
+	if bytesBurst <= 0 {
+		bytesBurst = framing.MaxFrameSize
+	}
+	return &pipeline{
+		client:     client,
+		maxWindow:  window,
+		window:     window,
+		maxRetries: config.BatchMaxRetries,
+		betsRate:   newRateLimiter(config.BetsPerSecond, betsBurst),
+		bytesRate:  newRateLimiter(config.BytesPerSecond, bytesBurst),
+		inflight:   make(map[uint64]*inflightBatch),
+	}
+}
+
+// open Connects unless the connection is already open. A failed attempt
+// is retried like a lost connection
+func (p *pipeline) open(ctx context.Context) error {
+	if p.stopReader != nil {
+		return nil
+	}
+	if err := p.connect(ctx); err != nil {
+		if refused(err) {
+			return err
+		}
+		return p.reconnect(ctx, err)
+	}
+	return nil
+}
+
+// run Sends every batch of the builder and returns once all of them were
+// answered by the server. Batch IDs keep growing from one run to the next.
+// The connection is left open so the caller can keep using it
+func (p *pipeline) run(ctx context.Context, builder *batchBuilder) error {
+	p.builder, p.fresh, p.exhausted, p.rejected = builder, nil, false, 0
+	if err := p.open(ctx); err != nil {
+		return err
+	}
+	p.watch(ctx)
+	defer p.unwatch()
+
+	clk := p.client.clock
+	nextSend := clk.Now()
+	for {
+		next, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if next == nil && len(p.inflight) == 0 {
+			return nil
+		}
+
+		canSend := next != nil && len(p.inflight) < p.window
+		now := clk.Now()
+		if canSend {
+			throttled, err := p.throttle(now, next)
+			if err != nil {
+				return err
+			}
+			if now.Add(throttled).After(nextSend) {
+				nextSend = now.Add(throttled)
+			}
+		}
+		if canSend && !now.Before(nextSend) {
+			if err := p.send(next); err != nil {
+				if err := p.connectionLost(ctx, err); err != nil {
+					return err
+				}
+			}
+			// Wait a time between sending one batch and the next one
+			nextSend = now.Add(p.client.config.LoopPeriod)
+			continue
+		}
+
+		var wake <-chan time.Time
+		var timer clock.Timer
+		if canSend {
+			timer = clk.NewTimer(nextSend.Sub(now))
+			wake = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-wake:
+		case r := <-p.replies:
+			if r.err != nil {
+				err = p.connectionLost(ctx, r.err)
+			} else {
+				err = p.handleReply(r.msg)
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// awaitDraw Waits until the server pushes the draw through the connection
+// or timeout elapses
+func (p *pipeline) awaitDraw(ctx context.Context, timeout time.Duration) error {
+	timer := p.client.clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return errors.Errorf("the draw was not announced within %v", timeout)
+	case r := <-p.replies:
+		if r.err != nil {
+			return r.err
+		}
+		switch r.msg.(type) {
+		case codec.DrawCompleteMessage:
+			return nil
+		case codec.DrawMissedMessage:
+			return ErrDrawMissed
+		}
+		return &UnexpectedMessageError{Got: r.msg.Type(), Want: codec.TypeDrawComplete}
+	}
+}
+
+// close Stops reading from the connection and closes it
+func (p *pipeline) close() {
+	if p.stopReader == nil {
+		return
+	}
+	close(p.stopReader)
+	p.unwatch()
+	p.client.closeClientSocket()
+	p.stopReader = nil
+}
+
+// connect Opens a new connection, registers the agency through it and
+// starts reading the replies sent through it
+func (p *pipeline) connect(ctx context.Context) error {
+	if err := p.client.createClientSocket(ctx); err != nil {
+		return err
+	}
+	p.watch(ctx)
+	if err := p.register(); err != nil {
+		p.unwatch()
+		p.client.closeClientSocket()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !refused(err) {
+			p.client.connectionFailed()
+		}
+		return err
+	}
+	p.replies = make(chan reply)
+	p.stopReader = make(chan struct{})
+	conn, connID := p.client.conn, p.client.connID
+	go readReplies(func() (codec.Message, error) {
+		return p.client.receiveFrom(conn, connID)
+	}, p.replies, p.stopReader)
+	return nil
+}
+
+// register Announces the agency to the server, which refuses it when the
+// agency is not in its roster or the draw took place without it. Servers
+// that predate registration reject the message like an unknown batch, in
+// which case the agency is taken as registered. Fails with
+// ErrCommitmentChanged when a registration after a reconnection does not
+// answer the commitment of the first one
+func (p *pipeline) register() error {
+	c := p.client
+	if err := c.send(codec.RegisterMessage{Agency: c.config.ID, Round: c.config.Round}); err != nil {
+		return err
+	}
+	msg, err := c.receive()
+	if err != nil {
+		return err
+	}
+
+	commitment := ""
+	switch reply := msg.(type) {
+	case codec.RegisteredMessage:
+		switch reply.Status {
+		case codec.RegisterOK:
+			commitment = reply.Commitment
+		case codec.RegisterUnknown:
+			return ErrNotInRoster
+		case codec.RegisterClosed:
+			return ErrDrawMissed
+		default:
+			return errors.Errorf("unknown register status %v", reply.Status)
+		}
+		c.log.Debugf("action: register | result: success | client_id: %v | commitment: %v", c.config.ID, reply.Commitment)
+	case codec.AckMessage:
+		c.log.Debugf("action: register | result: success | client_id: %v | server_registration: false", c.config.ID)
+	default:
+		return &UnexpectedMessageError{Got: msg.Type(), Want: codec.TypeRegistered}
+	}
+
+	// The draw is pinned by the first registration, so a server cannot
+	// swap the commitment, or drop it to skip the verification, when the
+	// agency registers again after a reconnection
+	if c.registered && commitment != c.commitment {
+		return ErrCommitmentChanged
+	}
+	c.registered, c.commitment = true, commitment
+	return nil
+}
+
+// refused Whether the server refused the agency, or broke its commitment,
+// which no retry changes
+func refused(err error) bool {
+	return err == ErrNotInRoster || err == ErrDrawMissed || err == ErrCommitmentChanged
+}
+
+// watch Closes the connection as soon as ctx is cancelled, until unwatch
+// is called or another context is watched
+func (p *pipeline) watch(ctx context.Context) {
+	p.unwatch()
+	p.stopWatch = closeOnCancel(ctx, p.client.conn)
+}
+
+func (p *pipeline) unwatch() {
+	if p.stopWatch != nil {
+		p.stopWatch()
+		p.stopWatch = nil
+	}
+}
+
+// readReplies Forwards every message returned by read until reading fails
+// or stop is closed
+func readReplies(read func() (codec.Message, error), replies chan<- reply, stop <-chan struct{}) {
+	for {
+		msg, err := read()
+		select {
+		case replies <- reply{msg: msg, err: err}:
+		case <-stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// peek Returns the batch to be sent next without removing it. Batches
+// waiting to be sent again go before new ones. Nil is returned once there
+// is nothing left to send
+func (p *pipeline) peek() (*inflightBatch, error) {
+	if len(p.retransmit) > 0 {
+		return p.retransmit[0], nil
+	}
+	if p.fresh == nil && !p.exhausted {
+		batch, err := p.builder.next()
+		if err != nil {
+			if err == io.EOF {
+				p.exhausted = true
+				return nil, nil
+			}
+			return nil, err
+		}
+		p.nextID++
+		batch.ID = p.nextID
+		batch.Round = p.client.config.Round
+		p.fresh = &inflightBatch{batch: batch}
+	}
+	return p.fresh, nil
+}
+
+// throttle Time left until the rate limits allow sending the batch
+func (p *pipeline) throttle(now time.Time, b *inflightBatch) (time.Duration, error) {
+	delay := p.betsRate.delay(now, len(b.batch.Bets))
+	if p.bytesRate == nil {
+		return delay, nil
+	}
+	if b.size == 0 {
+		payload, err := codec.Encode(b.batch)
+		if err != nil {
+			return 0, err
+		}
+		b.size = framing.HeaderSize + len(payload)
+	}
+	if bytesDelay := p.bytesRate.delay(now, b.size); bytesDelay > delay {
+		delay = bytesDelay
+	}
+	return delay, nil
+}
+
+// send Writes the batch returned by peek and tracks it as in flight
+func (p *pipeline) send(b *inflightBatch) error {
+	if len(p.retransmit) > 0 && p.retransmit[0] == b {
+		p.retransmit = p.retransmit[1:]
+	} else {
+		p.fresh = nil
+	}
+
+	b.attempts++
+	b.sentAt = p.client.clock.Now()
+	p.betsRate.take(b.sentAt, len(b.batch.Bets))
+	p.bytesRate.take(b.sentAt, b.size)
+	p.inflight[b.batch.ID] = b
+	if b.attempts == 1 {
+		p.client.metrics.BetsSent(len(b.batch.Bets))
+	} else {
+		p.client.metrics.Retry()
+	}
+	return p.client.send(b.batch)
+}
+
+// handleReply Processes the ack of an in flight batch
+func (p *pipeline) handleReply(msg codec.Message) error {
+	ack, ok := msg.(codec.AckMessage)
+	if !ok {
+		return &UnexpectedMessageError{Got: msg.Type(), Want: codec.TypeAck}
+	}
+	b, ok := p.inflight[ack.BatchID]
+	if !ok {
+		return errors.Errorf("ack for batch %d which is not in flight", ack.BatchID)
+	}
+	delete(p.inflight, ack.BatchID)
+
+	c := p.client
+	rtt := c.clock.Now().Sub(b.sentAt)
+	c.metrics.ObserveRTT(rtt)
+
+	switch ack.Status {
+	case codec.AckOK:
+		if p.window < p.maxWindow {
+			p.window++
+		}
+		c.metrics.BatchAcked()
+		c.progress.batchDone(len(b.batch.Bets), rtt, true)
+		if c.commitment != "" {
+			c.recordCommittedBets(b.batch.Bets)
+		}
+		c.log.Infof("action: apuesta_enviada | result: success | client_id: %v | batch_id: %v | cantidad: %v",
+			c.config.ID,
+			b.batch.ID,
+			len(b.batch.Bets),
+		)
+		return nil
+	case codec.AckBusy:
+		p.window = (p.window + 1) / 2
+		c.log.Debugf("action: backpressure | result: in_progress | client_id: %v | batch_id: %v | window: %v",
+			c.config.ID,
+			b.batch.ID,
+			p.window,
+		)
+		if b.attempts <= p.maxRetries {
+			p.enqueueRetransmit(b)
+			return nil
+		}
+	}
+
+	c.metrics.BatchFailed()
+	c.progress.batchDone(len(b.batch.Bets), rtt, false)
+	p.rejected += len(b.batch.Bets)
+	c.log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | batch_id: %v | cantidad: %v | status: %v",
+		c.config.ID,
+		b.batch.ID,
+		len(b.batch.Bets),
+		ack.Status,
+	)
+	return nil
+}
+
+// connectionLost Accounts the failure of the connection that was open
+// and reconnects. Connections that could not be opened were already
+// accounted when they failed
+func (p *pipeline) connectionLost(ctx context.Context, cause error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	p.client.connectionFailed()
+	return p.reconnect(ctx, cause)
+}
+
+// reconnect Reconnects after the connection failed, or could not be opened
+// at all, and schedules every in flight batch to be sent again. Gives up once some batch or the
+// reconnection exhausts the configured retries
+func (p *pipeline) reconnect(ctx context.Context, cause error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	c := p.client
+	c.log.Errorf("action: connection_lost | result: fail | client_id: %v | in_flight: %v | error: %v",
+		c.config.ID,
+		len(p.inflight),
+		cause,
+	)
+	p.close()
+
+	for _, b := range p.inflight {
+		if b.attempts > p.maxRetries {
+			c.metrics.BatchFailed()
+			return errors.Wrapf(cause, "batch %d failed after %d attempts", b.batch.ID, b.attempts)
+		}
+		p.enqueueRetransmit(b)
+	}
+	p.inflight = make(map[uint64]*inflightBatch)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > p.maxRetries {
+			return cause
+		}
+		// An open circuit breaker delays the attempt until its cool-down
+		// elapses instead of refusing it
+		wait := c.config.LoopPeriod
+		if retryIn := c.breaker.retryIn(); retryIn > wait {
+			wait = retryIn
+		}
+		if err := c.sleep(ctx, wait); err != nil {
+			return err
+		}
+		err := p.connect(ctx)
+		if err == nil {
+			c.log.Infof("action: reconnect | result: success | client_id: %v | attempt: %v", c.config.ID, attempt)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if refused(err) {
+			return err
+		}
+		cause = err
+	}
+}
+
+// enqueueRetransmit Schedules the batch to be sent again keeping the
+// retransmissions ordered by batch ID
+func (p *pipeline) enqueueRetransmit(b *inflightBatch) {
+	p.retransmit = append(p.retransmit, b)
+	sort.Slice(p.retransmit, func(i, j int) bool {
+		return p.retransmit[i].batch.ID < p.retransmit[j].batch.ID
+	})
+}
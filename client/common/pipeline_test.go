@@ -0,0 +1,152 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+// betRows Builds n valid rows with consecutive documents
+func betRows(n int) []string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = fmt.Sprintf("Santiago Lionel,Lorca,%d,1999-03-17,%d", 30000000+i, 1000+i)
+	}
+	return rows
+}
+
+func TestPipelineKeepsSeveralBatchesInFlight(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchWindow: 4, BetsFile: manyBetsFile(t, 20)}
+	s := startScriptedClient(t, config)
+
+	// Acks are held until the whole window was sent, which a client
+	// waiting for each ack would never do
+	conn := s.acceptUpload()
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 4; id++ {
+		receiveBatch(t, conn, id, 2)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	for id := uint64(1); id <= 10; id++ {
+		send(t, conn, codec.AckMessage{BatchID: id, Status: codec.AckOK})
+		if id <= 6 {
+			receiveBatch(t, conn, id+4, 2)
+		}
+	}
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+// expectNothingSent Checks the client does not send anything for a while
+func expectNothingSent(t *testing.T, conn *lotterytest.Conn) {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := conn.Receive(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("client sent %v, %v while its window was full", msg, err)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineShrinksTheWindowOnBackpressure(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchWindow: 4, BatchMaxRetries: 1, BetsFile: manyBetsFile(t, 12)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	for id := uint64(1); id <= 4; id++ {
+		receiveBatch(t, conn, id, 2)
+	}
+	// A busy ack halves the window to two batches, so the three still in
+	// flight keep the client from sending anything
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckBusy})
+	expectNothingSent(t, conn)
+
+	// Every ack grows the window back by one batch
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckOK})
+	receiveBatch(t, conn, 1, 2)
+	expectNothingSent(t, conn)
+
+	send(t, conn, codec.AckMessage{BatchID: 3, Status: codec.AckOK})
+	receiveBatch(t, conn, 5, 2)
+	receiveBatch(t, conn, 6, 2)
+	for _, id := range []uint64{4, 1, 5, 6} {
+		send(t, conn, codec.AckMessage{BatchID: id, Status: codec.AckOK})
+	}
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestPipelineRetransmitsBatchesOnBackpressure(t *testing.T) {
+	server := startServer(t, 1)
+	// Every batch is answered busy the first time it is received
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	server.AnswerBatches(func(batch codec.BetBatchMessage) codec.AckStatus {
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[batch.ID] {
+			seen[batch.ID] = true
+			return codec.AckBusy
+		}
+		return codec.AckOK
+	})
+
+	config := testConfig(server, writeBetsFile(t, betRows(6)...))
+	config.LoopPeriod = 0
+	config.BatchWindow = 2
+	config.BatchMaxRetries = 1
+
+	client := NewClient(config)
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+
+	if bets := server.Bets(); len(bets) != 6 {
+		t.Fatalf("server stored %d bets, want 6", len(bets))
+	}
+	snapshot := client.Metrics().Snapshot()
+	if snapshot.Retries != 3 || snapshot.BatchesAcked != 3 || snapshot.BatchesFailed != 0 {
+		t.Errorf("retries %d, batches acked %d, batches failed %d, want 3, 3, 0",
+			snapshot.Retries, snapshot.BatchesAcked, snapshot.BatchesFailed)
+	}
+}
+
+func TestPipelineGivesUpOnBusyBatchesWithoutRetries(t *testing.T) {
+	server := startServer(t, 1)
+	server.AnswerBatches(func(batch codec.BetBatchMessage) codec.AckStatus {
+		return codec.AckBusy
+	})
+
+	config := testConfig(server, writeBetsFile(t, betRows(4)...))
+	client := NewClient(config)
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+
+	snapshot := client.Metrics().Snapshot()
+	if snapshot.Retries != 0 || snapshot.BatchesFailed != 2 {
+		t.Errorf("retries %d, batches failed %d, want 0, 2", snapshot.Retries, snapshot.BatchesFailed)
+	}
+}
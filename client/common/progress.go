@@ -0,0 +1,191 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+)
+
+// progressReporter Keeps track of the upload of an agency file and
+// periodically logs how much of it was sent and how long it will take
+type progressReporter struct {
+	log      Logger
+	clock    clock.Clock
+	clientID string
+	start    time.Time
+
+	mu            sync.Mutex
+	total         int
+	unknownTotal  bool
+	betsSent      int
+	betsFailed    int
+	batches       int
+	failedBatches int
+	rttSum        time.Duration
+}
+
+func newProgressReporter(log Logger, clk clock.Clock, clientID string, total int) *progressReporter {
+	return &progressReporter{
+		log:      log,
+		clock:    clk,
+		clientID: clientID,
+		total:    total,
+		start:    clk.Now(),
+	}
+}
+
+// expect Adds bets to the amount expected to be uploaded
+func (p *progressReporter) expect(bets int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total += bets
+}
+
+// expectUnknown Records that bets whose amount is not known beforehand
+// will be uploaded, so only the amount processed can be reported
+func (p *progressReporter) expectUnknown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unknownTotal = true
+}
+
+// batchDone Accounts a batch once the server answered it
+func (p *progressReporter) batchDone(bets int, rtt time.Duration, acked bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches++
+	p.rttSum += rtt
+	if acked {
+		p.betsSent += bets
+	} else {
+		p.betsFailed += bets
+		p.failedBatches++
+	}
+}
+
+// run Logs the progress every period until ctx is cancelled. A non positive
+// period disables the periodic logs
+func (p *progressReporter) run(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+
+	timer := p.clock.NewTimer(period)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			p.report()
+			timer.Reset(period)
+		}
+	}
+}
+
+// report Logs the percentage of bets processed, the upload rate and the
+// estimated time left. Only the amount processed and the rate are logged
+// when the amount of bets to upload is unknown
+func (p *progressReporter) report() {
+	p.mu.Lock()
+	processed, total, unknownTotal := p.betsSent+p.betsFailed, p.total, p.unknownTotal
+	p.mu.Unlock()
+
+	rate, eta := estimateProgress(processed, total, p.clock.Now().Sub(p.start))
+	if unknownTotal {
+		p.log.Infof("action: progress | result: in_progress | client_id: %v | bets_processed: %v | bets_per_second: %.1f",
+			p.clientID,
+			processed,
+			rate,
+		)
+		return
+	}
+	p.log.Infof("action: progress | result: in_progress | client_id: %v | percent: %.2f | bets_per_second: %.1f | eta: %v",
+		p.clientID,
+		percentage(processed, total),
+		rate,
+		eta,
+	)
+}
+
+// summary Logs the totals of the upload once it finished
+func (p *progressReporter) summary() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var avgRTT time.Duration
+	if p.batches > 0 {
+		avgRTT = p.rttSum / time.Duration(p.batches)
+	}
+	p.log.Infof("action: upload_summary | result: success | client_id: %v | bets_sent: %v | bets_failed: %v | batches: %v | batches_failed: %v | elapsed: %v | avg_batch_rtt: %v",
+		p.clientID,
+		p.betsSent,
+		p.betsFailed,
+		p.batches,
+		p.failedBatches,
+		p.clock.Now().Sub(p.start).Round(time.Millisecond),
+		avgRTT,
+	)
+}
+
+// estimateProgress Returns the amount of bets processed per second and the
+// time left to process the remaining ones at that rate
+func estimateProgress(processed int, total int, elapsed time.Duration) (float64, time.Duration) {
+	if processed <= 0 || elapsed <= 0 {
+		return 0, 0
+	}
+
+	rate := float64(processed) / elapsed.Seconds()
+	remaining := total - processed
+	if remaining <= 0 {
+		return rate, 0
+	}
+	eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+	return rate, eta.Round(time.Second)
+}
+
+func percentage(processed int, total int) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(processed) * 100 / float64(total)
+}
+
+// countRows Counts the lines of the file to know the amount of bets to
+// upload before starting
+func countRows(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	rows := 0
+	last := byte('\n')
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			rows += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// The last row may not end with a line break
+	if last != '\n' {
+		rows++
+	}
+	return rows, nil
+}
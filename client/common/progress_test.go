@@ -0,0 +1,58 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+)
+
+func TestEstimateProgress(t *testing.T) {
+	rate, eta := estimateProgress(250, 1000, 5*time.Second)
+	if rate != 50 {
+		t.Errorf("rate = %v, want 50", rate)
+	}
+	if eta != 15*time.Second {
+		t.Errorf("eta = %v, want 15s", eta)
+	}
+
+	if rate, eta := estimateProgress(0, 1000, time.Second); rate != 0 || eta != 0 {
+		t.Errorf("estimate without progress = %v, %v, want 0, 0", rate, eta)
+	}
+	if _, eta := estimateProgress(1000, 1000, time.Second); eta != 0 {
+		t.Errorf("eta once finished = %v, want 0", eta)
+	}
+}
+
+func TestCountRows(t *testing.T) {
+	for name, content := range map[string]string{
+		"trailing line break": "a,b\nc,d\ne,f\n",
+		"last row unfinished": "a,b\nc,d\ne,f",
+	} {
+		path := filepath.Join(t.TempDir(), "agency.csv")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := countRows(path)
+		if err != nil || rows != 3 {
+			t.Errorf("%s: countRows() = %v, %v, want 3, nil", name, rows, err)
+		}
+	}
+}
+
+func TestProgressReportWithoutTotal(t *testing.T) {
+	logger := &recordingLogger{}
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	progress := newProgressReporter(logger, fake, "1", 0)
+	progress.expectUnknown()
+	progress.batchDone(3, time.Millisecond, true)
+	fake.Advance(time.Second)
+	progress.report()
+
+	want := "action: progress | result: in_progress | client_id: 1 | bets_processed: 3 | bets_per_second: 3.0"
+	if len(logger.lines) != 1 || logger.lines[0] != want {
+		t.Errorf("report() logged %q, want %q", logger.lines, want)
+	}
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// rateLimiter Token bucket refilled at rate tokens per second up to burst
+// tokens. A nil limiter never delays anything. It is not safe for
+// concurrent use, every send is paced from the pipeline loop
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter Returns a limiter that starts with a full bucket, or nil
+// when rate is not positive. A burst lower than one token is raised to one
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+}
+
+// delay Time left until n tokens can be taken. Requests larger than the
+// burst are allowed as soon as the bucket is full, leaving it in debt so
+// the average rate is kept
+func (l *rateLimiter) delay(now time.Time, n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.refill(now)
+	need := math.Min(float64(n), l.burst)
+	if l.tokens >= need {
+		return 0
+	}
+	return time.Duration(math.Ceil((need - l.tokens) / l.rate * float64(time.Second)))
+}
+
+// take Consumes n tokens
+func (l *rateLimiter) take(now time.Time, n int) {
+	if l == nil {
+		return
+	}
+	l.refill(now)
+	l.tokens -= float64(n)
+}
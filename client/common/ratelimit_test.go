@@ -0,0 +1,85 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsUpToBurst(t *testing.T) {
+	start := time.Unix(0, 0)
+	limiter := newRateLimiter(10, 5)
+
+	if d := limiter.delay(start, 5); d != 0 {
+		t.Fatalf("delay() with a full bucket = %v, want 0", d)
+	}
+	limiter.take(start, 5)
+	if d := limiter.delay(start, 2); d != 200*time.Millisecond {
+		t.Errorf("delay() of 2 tokens on an empty bucket = %v, want 200ms", d)
+	}
+
+	// A long pause refills the bucket up to its burst only
+	later := start.Add(time.Hour)
+	limiter.take(later, 5)
+	if d := limiter.delay(later, 1); d != 100*time.Millisecond {
+		t.Errorf("delay() after an idle hour = %v, want 100ms", d)
+	}
+}
+
+func TestRateLimiterAllowsRequestsLargerThanBurst(t *testing.T) {
+	start := time.Unix(0, 0)
+	limiter := newRateLimiter(10, 5)
+
+	if d := limiter.delay(start, 20); d != 0 {
+		t.Fatalf("delay() of 20 tokens with a full bucket = %v, want 0", d)
+	}
+	limiter.take(start, 20)
+	// The bucket is 15 tokens in debt, so a whole burst takes 2 seconds
+	if d := limiter.delay(start, 5); d != 2*time.Second {
+		t.Errorf("delay() in debt = %v, want 2s", d)
+	}
+}
+
+func TestNilRateLimiterNeverDelays(t *testing.T) {
+	limiter := newRateLimiter(0, 10)
+	limiter.take(time.Now(), 1000)
+	if d := limiter.delay(time.Now(), 1000); d != 0 {
+		t.Errorf("delay() without a rate = %v, want 0", d)
+	}
+}
+
+func TestPipelinePacesBetsPerSecond(t *testing.T) {
+	server := startServer(t, 1)
+	config := testConfig(server, manyBetsFile(t, 10))
+	config.BatchWindow = 5
+	config.BetsPerSecond = 100
+	config.BetsBurst = 2
+
+	start := time.Now()
+	if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	// The first batch uses the burst and the other 8 bets take 80ms
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("10 bets at 100 bets/s took %v, want at least 80ms", elapsed)
+	}
+	assertBetsStoredOnce(t, server, 10)
+}
+
+func TestPipelineRateLimitStopsWhenContextIsCancelled(t *testing.T) {
+	server := startServer(t, 1)
+	config := testConfig(server, manyBetsFile(t, 10))
+	config.BytesPerSecond = 1
+	config.BytesBurst = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := NewClient(config).StartClientLoop(ctx); err != context.Canceled {
+		t.Fatalf("StartClientLoop() = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled client took %v to stop", elapsed)
+	}
+}
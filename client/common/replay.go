@@ -0,0 +1,227 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// captureTimeLayout Timestamp included in the name of capture files
+const captureTimeLayout = "20060102T150405Z"
+
+// DefaultReplayTimeout Time the server has to answer each replayed request
+// when no timeout is given
+const DefaultReplayTimeout = 5 * time.Second
+
+// startCapture Creates a capture file named after the agency and the
+// current time in the configured directory
+func (c *Client) startCapture() error {
+	name := fmt.Sprintf("capture-%s-%s.lcap", c.config.ID, c.clock.Now().UTC().Format(captureTimeLayout))
+	path := filepath.Join(c.config.CaptureDir, name)
+
+	writer, err := capture.Create(path, c.clock.Now)
+	if err != nil {
+		c.log.Errorf("action: capture | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	c.capture = writer
+	c.log.Infof("action: capture | result: in_progress | client_id: %v | file: %v", c.config.ID, path)
+	return nil
+}
+
+// stopCapture Closes the capture file. A capture that could not be written
+// did not affect the session itself
+func (c *Client) stopCapture() error {
+	err := c.capture.Close()
+	if err != nil {
+		c.log.Errorf("action: capture | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	} else {
+		c.log.Infof("action: capture | result: success | client_id: %v", c.config.ID)
+	}
+	c.capture = nil
+	return err
+}
+
+// ReplayDifference Response of the server that does not match the one
+// recorded in the capture. Response is the position of the response within
+// its connection, starting at 1
+type ReplayDifference struct {
+	Connection uint32
+	Response   int
+	Want       string
+	Got        string
+}
+
+// ReplayReport Result of replaying a capture against a server
+type ReplayReport struct {
+	File        string
+	Connections int
+	Requests    int
+	Responses   int
+	Differences []ReplayDifference
+}
+
+// Matches Whether every response of the server matched the capture
+func (r ReplayReport) Matches() bool {
+	return len(r.Differences) == 0
+}
+
+// WriteText Writes the differences as a diff between the recorded and the
+// replayed responses followed by a summary line
+func (r ReplayReport) WriteText(w io.Writer) error {
+	for _, d := range r.Differences {
+		if _, err := fmt.Fprintf(w, "connection %d, response %d:\n- %s\n+ %s\n", d.Connection, d.Response, d.Want, d.Got); err != nil {
+			return err
+		}
+	}
+
+	result := "responses match"
+	if !r.Matches() {
+		result = fmt.Sprintf("%d responses differ", len(r.Differences))
+	}
+	_, err := fmt.Fprintf(w, "%s: %d connections, %d requests, %d responses, %s\n",
+		r.File, r.Connections, r.Requests, r.Responses, result)
+	return err
+}
+
+// Replay Sends the requests recorded in the capture file to the server and
+// compares its responses with the recorded ones. Every connection is
+// replayed concurrently, opened at the same time since the start of the
+// capture as during the session, so sessions that overlapped do it again.
+// Failing to reach the server or to read the capture is returned as error,
+// while wrong or missing responses are reported as differences. tlsConfig
+// is only used by tls:// endpoints
+func Replay(ctx context.Context, endpoint string, path string, timeout time.Duration, tlsConfig *tls.Config) (ReplayReport, error) {
+	if timeout <= 0 {
+		timeout = DefaultReplayTimeout
+	}
+	report := ReplayReport{File: path}
+
+	records, err := capture.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+
+	var order []uint32
+	var first time.Time
+	sessions := make(map[uint32][]capture.Record)
+	for _, record := range records {
+		if first.IsZero() || record.Time.Before(first) {
+			first = record.Time
+		}
+		if _, ok := sessions[record.Connection]; !ok {
+			order = append(order, record.Connection)
+		}
+		sessions[record.Connection] = append(sessions[record.Connection], record)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	transports := newTransports(newAddressCache(nil, DefaultResolveTTL), &net.Dialer{}, tlsConfig)
+	reports := make([]ReplayReport, len(order))
+	errs := make([]error, len(order))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, connection := range order {
+		wg.Add(1)
+		go func(i int, connection uint32) {
+			defer wg.Done()
+			records := sessions[connection]
+			if err := waitUntil(ctx, start.Add(records[0].Time.Sub(first))); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = replayConnection(ctx, transports, endpoint, timeout, connection, records, &reports[i])
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, connection)
+	}
+	wg.Wait()
+
+	// Connections are reported in the order they were opened, whatever
+	// the order they finished in
+	for i := range order {
+		if errs[i] != nil && errs[i] != context.Canceled {
+			return report, errs[i]
+		}
+		report.Connections += reports[i].Connections
+		report.Requests += reports[i].Requests
+		report.Responses += reports[i].Responses
+		report.Differences = append(report.Differences, reports[i].Differences...)
+	}
+	return report, ctx.Err()
+}
+
+// replayConnection Replays the records of a single connection. Once the
+// server stops answering the remaining responses are reported as missing
+func replayConnection(ctx context.Context, transports map[string]Transport, endpoint string, timeout time.Duration, connection uint32, records []capture.Record, report *ReplayReport) error {
+	conn, err := dialEndpoint(ctx, transports, endpoint)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer closeOnCancel(ctx, conn)()
+	report.Connections++
+
+	var broken error
+	responses := 0
+	for _, record := range records {
+		if record.Direction == capture.Sent {
+			if broken != nil {
+				continue
+			}
+			report.Requests++
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			broken = framing.WriteFrame(conn, record.Payload)
+			continue
+		}
+
+		responses++
+		report.Responses++
+		got := []byte(nil)
+		if broken == nil {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			got, broken = framing.ReadFrame(conn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if broken != nil {
+			report.addDifference(connection, responses, record.Payload, fmt.Sprintf("<no response: %v>", broken))
+		} else if !bytes.Equal(got, record.Payload) {
+			report.addDifference(connection, responses, record.Payload, string(got))
+		}
+	}
+	return nil
+}
+
+// waitUntil Sleeps until the given time or until ctx is cancelled
+func waitUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *ReplayReport) addDifference(connection uint32, response int, want []byte, got string) {
+	r.Differences = append(r.Differences, ReplayDifference{
+		Connection: connection,
+		Response:   response,
+		Want:       string(want),
+		Got:        got,
+	})
+}
@@ -0,0 +1,169 @@
+package common
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// recordSession Runs the client against server recording its frames and
+// returns the path of the capture
+func recordSession(t *testing.T, config ClientConfig, options ...Option) string {
+	t.Helper()
+	config.CaptureDir = t.TempDir()
+	if err := NewClient(config, options...).StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(config.CaptureDir, "capture-1-*.lcap"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("capture files = %v, %v", files, err)
+	}
+	return files[0]
+}
+
+func TestClientRecordsSession(t *testing.T) {
+	server := startServer(t, 1)
+	betsFile := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,1987-08-01,8676",
+		"Nicolás,Peña,27726965,1994-03-16,7574",
+	)
+	path := recordSession(t, testConfig(server, betsFile))
+
+	records, err := capture.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 6 {
+		t.Fatalf("capture has %d records, want at least 6", len(records))
+	}
+	first, last := records[0], records[len(records)-1]
+	if first.Direction != capture.Sent || first.Connection != 1 {
+		t.Errorf("first record = %+v", first)
+	}
+	if msg, err := codec.Decode(first.Payload); err != nil || msg.Type() != codec.TypeRegister {
+		t.Errorf("first record holds %v, %v", msg, err)
+	}
+	if last.Direction != capture.Received || last.Connection == 1 {
+		t.Errorf("last record = %+v", last)
+	}
+	if msg, err := codec.Decode(last.Payload); err != nil || msg.Type() != codec.TypeWinnersNotification {
+		t.Errorf("last record holds %v, %v", msg, err)
+	}
+}
+
+func TestReplayReportsDifferentResponses(t *testing.T) {
+	server := startServer(t, 1)
+	betsFile := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,1987-08-01,8676",
+		"Nicolás,Peña,27726965,1994-03-16,7574",
+	)
+	path := recordSession(t, testConfig(server, betsFile))
+	pending, _ := codec.Encode(codec.WinnersPendingMessage{})
+
+	// The same server answers the same, except for the winners requests
+	// that were answered before the draw
+	report, err := Replay(context.Background(), server.Addr, path, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Responses == 0 || report.Connections < 2 {
+		t.Errorf("report = %+v", report)
+	}
+	for _, d := range report.Differences {
+		if d.Want != string(pending) {
+			t.Errorf("unexpected difference %+v", d)
+		}
+	}
+
+	busy := startServer(t, 1)
+	busy.AnswerBatches(func(codec.BetBatchMessage) codec.AckStatus { return codec.AckBusy })
+	report, err = Replay(context.Background(), busy.Addr, path, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReplayDifference{Connection: 1, Response: 2, Want: "AckMessage|1|0", Got: "AckMessage|1|2"}
+	if report.Matches() || report.Differences[0] != want {
+		t.Errorf("differences = %+v, want first %+v", report.Differences, want)
+	}
+}
+
+func TestClientTimestampsCaptureWithItsClock(t *testing.T) {
+	server := startServer(t, 1)
+	config := testConfig(server, writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574"))
+	config.LoopPeriod = 0
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := recordSession(t, config, WithClock(clock.NewFake(start)))
+
+	// The file name and every record take the time from the same clock
+	if want := "capture-1-" + start.Format(captureTimeLayout) + ".lcap"; filepath.Base(path) != want {
+		t.Errorf("capture file = %s, want %s", filepath.Base(path), want)
+	}
+	records, err := capture.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if !record.Time.Equal(start) {
+			t.Fatalf("record timestamped %v, want %v", record.Time, start)
+		}
+	}
+}
+
+func TestReplayOverlapsConnectionsLikeTheSession(t *testing.T) {
+	// Both connections sent their ping before either was answered, so the
+	// server below only answers once both pings arrived
+	path := filepath.Join(t.TempDir(), "overlap.lcap")
+	writer, err := capture.Create(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ping, _ := codec.Encode(codec.PingMessage{})
+	pong, _ := codec.Encode(codec.PongMessage{})
+	writer.Record(capture.Sent, 1, ping)
+	writer.Record(capture.Sent, 2, ping)
+	writer.Record(capture.Received, 1, pong)
+	writer.Record(capture.Received, 2, pong)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		for len(conns) < 2 {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if _, err := framing.ReadFrame(conn); err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			framing.WriteFrame(conn, pong)
+		}
+	}()
+
+	report, err := Replay(context.Background(), listener.Addr().String(), path, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Matches() || report.Connections != 2 || report.Responses != 2 {
+		t.Errorf("report = %+v, want both pongs matched", report)
+	}
+}
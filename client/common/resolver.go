@@ -0,0 +1,145 @@
+package common
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultResolveTTL Time resolved endpoints are cached when no TTL is given
+const DefaultResolveTTL = 30 * time.Second
+
+// Resolver Looks up the addresses of the server endpoints. It is satisfied
+// by *net.Resolver, so tests can inject a fake one instead of querying DNS
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// isSRVName Whether the endpoint names an SRV record, such as
+// _lottery._tcp.server, instead of a host:port address
+func isSRVName(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "_") && !strings.Contains(endpoint, ":")
+}
+
+// resolvedEndpoint Addresses an endpoint resolved to, valid until expires
+type resolvedEndpoint struct {
+	addresses []string
+	expires   time.Time
+	next      int
+}
+
+// addressCache Resolves endpoints into host:port addresses and caches them
+// for ttl. Endpoints whose connections fail are dropped from the cache so
+// they are resolved again. Every method is safe for concurrent use
+type addressCache struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+	log      Logger
+
+	mu      sync.Mutex
+	entries map[string]*resolvedEndpoint
+}
+
+func newAddressCache(resolver Resolver, ttl time.Duration) *addressCache {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &addressCache{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		log:      defaultLogger,
+		entries:  make(map[string]*resolvedEndpoint),
+	}
+}
+
+// addresses Returns every address of the endpoint. Consecutive calls start
+// at a different address so connections are spread among the replicas
+func (c *addressCache) addresses(ctx context.Context, endpoint string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[endpoint]
+	c.mu.Unlock()
+
+	if !ok || !c.now().Before(entry.expires) {
+		addresses, err := c.resolve(ctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		c.log.Debugf("action: resolve | result: success | server_address: %v | addresses: %v", endpoint, strings.Join(addresses, ","))
+
+		entry = &resolvedEndpoint{addresses: addresses, expires: c.now().Add(c.ttl)}
+		c.mu.Lock()
+		c.entries[endpoint] = entry
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := entry.next % len(entry.addresses)
+	entry.next++
+	return append(append([]string(nil), entry.addresses[start:]...), entry.addresses[:start]...), nil
+}
+
+// invalidate Drops the endpoint from the cache
+func (c *addressCache) invalidate(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, endpoint)
+}
+
+// resolve Looks up the SRV record or the host of the endpoint. IP
+// addresses are returned as they are
+func (c *addressCache) resolve(ctx context.Context, endpoint string) ([]string, error) {
+	if isSRVName(endpoint) {
+		_, records, err := c.resolver.LookupSRV(ctx, "", "", endpoint)
+		if err != nil {
+			return nil, err
+		}
+		var addresses []string
+		for _, record := range records {
+			hosts, err := c.lookupHost(ctx, strings.TrimSuffix(record.Target, "."))
+			if err != nil {
+				continue
+			}
+			for _, host := range hosts {
+				addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+			}
+		}
+		if len(addresses) == 0 {
+			return nil, errors.Errorf("no address found for %s", endpoint)
+		}
+		return addresses, nil
+	}
+
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := c.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(hosts))
+	for i, h := range hosts {
+		addresses[i] = net.JoinHostPort(h, port)
+	}
+	return addresses, nil
+}
+
+func (c *addressCache) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	hosts, err := c.resolver.LookupHost(ctx, host)
+	if err == nil && len(hosts) == 0 {
+		err = errors.Errorf("no address found for %s", host)
+	}
+	return hosts, err
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeResolver Answers lookups from maps and counts them
+type fakeResolver struct {
+	mu      sync.Mutex
+	hosts   map[string][]string
+	srv     map[string][]*net.SRV
+	lookups int
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if addresses, ok := r.hosts[host]; ok {
+		return addresses, nil
+	}
+	return nil, errors.Errorf("no such host %s", host)
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if records, ok := r.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, errors.Errorf("no such service %s", name)
+}
+
+func (r *fakeResolver) setHost(host string, addresses ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = addresses
+}
+
+func (r *fakeResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func TestAddressCacheResolvesRecords(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{"server": {"10.0.0.1", "10.0.0.2"}, "replica": {"10.0.0.3"}},
+		srv: map[string][]*net.SRV{"_lottery._tcp.server": {
+			{Target: "replica.", Port: 12345},
+			{Target: "server.", Port: 12346},
+		}},
+	}
+	cache := newAddressCache(resolver, time.Minute)
+
+	tests := []struct {
+		endpoint string
+		want     []string
+	}{
+		{endpoint: "server:12345", want: []string{"10.0.0.1:12345", "10.0.0.2:12345"}},
+		{endpoint: "127.0.0.1:12345", want: []string{"127.0.0.1:12345"}},
+		{endpoint: "_lottery._tcp.server", want: []string{"10.0.0.3:12345", "10.0.0.1:12346", "10.0.0.2:12346"}},
+	}
+	for _, tt := range tests {
+		got, err := cache.addresses(context.Background(), tt.endpoint)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("addresses(%q) = %v, %v, want %v", tt.endpoint, got, err, tt.want)
+		}
+	}
+
+	if _, err := cache.addresses(context.Background(), "unknown:1"); err == nil {
+		t.Error("addresses() of an unknown host did not fail")
+	}
+}
+
+func TestAddressCacheExpiresAndRotates(t *testing.T) {
+	now := time.Unix(0, 0)
+	resolver := &fakeResolver{hosts: map[string][]string{"server": {"10.0.0.1", "10.0.0.2"}}}
+	cache := newAddressCache(resolver, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	first, _ := cache.addresses(context.Background(), "server:1")
+	second, _ := cache.addresses(context.Background(), "server:1")
+	if first[0] != "10.0.0.1:1" || second[0] != "10.0.0.2:1" || resolver.count() != 1 {
+		t.Errorf("cached addresses = %v then %v after %d lookups", first, second, resolver.count())
+	}
+
+	cache.invalidate("server:1")
+	cache.addresses(context.Background(), "server:1")
+	now = now.Add(time.Minute)
+	cache.addresses(context.Background(), "server:1")
+	if resolver.count() != 3 {
+		t.Errorf("lookups = %d, want 3 after an invalidation and an expiration", resolver.count())
+	}
+}
+
+func TestClientResolvesEndpointAgainAfterFailure(t *testing.T) {
+	server := startServer(t, 1)
+	_, port, _ := net.SplitHostPort(server.Addr)
+
+	// The first answer points to an address where nothing listens
+	resolver := &fakeResolver{hosts: map[string][]string{"lottery": {"127.0.0.2"}}}
+	config := testConfig(server, manyBetsFile(t, 4))
+	config.ServerAddress = net.JoinHostPort("lottery", port)
+	config.Resolver = resolver
+	config.BatchMaxRetries = 2
+	config.LoopPeriod = 20 * time.Millisecond
+
+	client := NewClient(config)
+	time.AfterFunc(5*time.Millisecond, func() { resolver.setHost("lottery", "127.0.0.1") })
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	assertBetsStoredOnce(t, server, 4)
+
+	// Once resolved the address is cached for the winners requests too
+	if lookups := resolver.count(); lookups != 2 {
+		t.Errorf("lookups = %d, want 2", lookups)
+	}
+}
@@ -0,0 +1,435 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/draw"
+)
+
+var (
+	// ErrNotConnected Returned by the session methods called before Connect
+	ErrNotConnected = errors.New("client is not connected")
+	// ErrClosed Returned by the session methods called after Close
+	ErrClosed = errors.New("client is closed")
+	// ErrBetsDone Returned when bets are sent, or the server is notified
+	// again, once NotifyDone succeeded
+	ErrBetsDone = errors.New("bets were already notified as done")
+	// ErrNotInRoster Returned when the server does not expect the agency
+	// to take part in the draw
+	ErrNotInRoster = errors.New("agency is not in the roster of the server")
+	// ErrDrawMissed Returned when the draw took place without the agency,
+	// which did not finish sending its bets before the draw deadline
+	ErrDrawMissed = errors.New("the draw took place without the agency")
+	// ErrUnknownRound Returned when the winners of a round are asked for
+	// and the server knows nothing about the round, such as a round no
+	// agency took part in yet
+	ErrUnknownRound = errors.New("the server does not know the round")
+	// ErrCommitmentChanged Returned when the server commits to another
+	// draw, or to none, as the agency registers again after a reconnection
+	ErrCommitmentChanged = errors.New("the server changed the draw it committed to")
+	// ErrWinnersMismatch Returned when the winners the server answers are
+	// not the documents of the bets it acknowledged holding the winning
+	// number it revealed
+	ErrWinnersMismatch = errors.New("the winners are not the bets holding the winning number")
+)
+
+// RejectedError Returned by SendBets when the server did not store some of
+// the bets, either because it rejected their batch or because it was still
+// busy after every retry. The remaining bets were stored
+type RejectedError struct {
+	Bets int
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("server did not store %d bets", e.Bets)
+}
+
+// UnexpectedMessageError Returned when the server answers with a message
+// the protocol does not allow at that point
+type UnexpectedMessageError struct {
+	Got  string
+	Want string
+}
+
+func (e *UnexpectedMessageError) Error() string {
+	return fmt.Sprintf("unexpected %s while waiting for %s", e.Got, e.Want)
+}
+
+// Connect Starts the session of the agency: serves the metrics and starts
+// the capture when configured, waits for the server if a wait timeout is
+// set and opens the connection the bets are uploaded through. Failing to
+// connect is retried like a lost connection. ctx only bounds the connection
+// attempts. Close must be called even when Connect fails. The session
+// methods are not safe for concurrent use
+func (c *Client) Connect(ctx context.Context) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if c.upload != nil {
+		return nil
+	}
+	if c.configErr != nil {
+		c.log.Errorf("action: config | result: fail | client_id: %v | error: %v", c.config.ID, c.configErr)
+		return c.configErr
+	}
+
+	if c.config.MetricsAddress != "" && c.metricsServer == nil {
+		server, err := startMetricsServer(c.config.MetricsAddress, c.metrics, c.log)
+		if err != nil {
+			c.log.Errorf("action: metrics_server | result: fail | client_id: %v | error: %v",
+				c.config.ID,
+				err,
+			)
+			return err
+		}
+		c.metricsServer = server
+	}
+
+	if c.config.CaptureDir != "" && c.capture == nil {
+		if err := c.startCapture(); err != nil {
+			return err
+		}
+	}
+
+	if c.config.WaitTimeout > 0 {
+		if err := c.waitForServer(ctx); err != nil {
+			return err
+		}
+	}
+
+	if c.progress == nil {
+		c.progress = newProgressReporter(c.log, c.clock, c.config.ID, 0)
+		progressCtx, stopProgress := context.WithCancel(context.Background())
+		c.stopProgress = stopProgress
+		go c.progress.run(progressCtx, c.config.ProgressPeriod)
+	}
+
+	upload := newPipeline(c)
+	if err := upload.open(ctx); err != nil {
+		switch err {
+		case ErrDrawMissed:
+			c.drawMissed()
+		case ErrNotInRoster, ErrCommitmentChanged:
+			c.log.Errorf("action: register | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		}
+		return err
+	}
+	upload.unwatch()
+	c.upload = upload
+	return nil
+}
+
+// session Returns why bets cannot be uploaded, if they cannot
+func (c *Client) session() error {
+	switch {
+	case c.closed:
+		return ErrClosed
+	case c.upload == nil:
+		return ErrNotConnected
+	case c.notified:
+		return ErrBetsDone
+	}
+	return nil
+}
+
+// SendBets Uploads every bet of source and returns once the server
+// answered all of them. Bets that cannot be encoded are logged and
+// skipped. A *RejectedError is returned when the server did not store
+// some of the bets
+func (c *Client) SendBets(ctx context.Context, source BetSource) error {
+	if err := c.session(); err != nil {
+		return err
+	}
+
+	c.progress.expectUnknown()
+	return c.sendBets(ctx, source)
+}
+
+// sendBets Uploads the bets of source once the session was checked
+func (c *Client) sendBets(ctx context.Context, source BetSource) error {
+	builder := newBatchBuilder(source, c.config.BatchMaxAmount, func(line int, err error) {
+		c.log.Errorf("action: read_bet | result: fail | client_id: %v | line: %v | error: %v",
+			c.config.ID,
+			line,
+			err,
+		)
+	})
+	if err := c.upload.run(ctx, builder); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+	if c.upload.rejected > 0 {
+		return &RejectedError{Bets: c.upload.rejected}
+	}
+	return nil
+}
+
+// SendBetsFile Uploads the bets of the agency file at path like SendBets.
+// Rows that are not valid bets are logged and skipped
+func (c *Client) SendBetsFile(ctx context.Context, path string) error {
+	if err := c.session(); err != nil {
+		return err
+	}
+
+	reader, err := NewBetReader(path, c.config.ID)
+	total := 0
+	if err == nil {
+		defer reader.Close()
+		total, err = countRows(path)
+	}
+	if err != nil {
+		c.log.Errorf("action: open_bets_file | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+
+	c.progress.expect(total)
+	return c.sendBets(ctx, reader)
+}
+
+// NotifyDone Tells the server the agency will not send more bets. The
+// connection they were uploaded through is kept open, waiting for the draw,
+// when a draw timeout is configured and closed otherwise
+func (c *Client) NotifyDone(ctx context.Context) error {
+	if err := c.session(); err != nil {
+		return err
+	}
+
+	err := c.upload.open(ctx)
+	if err == nil {
+		c.upload.watch(ctx)
+		err = c.send(codec.EndOfBetsMessage{Agency: c.config.ID, Round: c.config.Round})
+		c.upload.unwatch()
+	}
+	if err != nil || c.config.DrawTimeout <= 0 {
+		c.upload.close()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log.Errorf("action: end_of_bets | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+
+	c.notified = true
+	c.log.Infof("action: end_of_bets | result: success | client_id: %v", c.config.ID)
+	// Nothing is uploaded while waiting for the draw
+	c.stopProgress()
+	c.progress.summary()
+	return nil
+}
+
+// QueryWinners Waits for the draw and returns the documents of the winners
+// of the agency, which are also handed to the result sink. When the server
+// committed to the winning number, the number it reveals is verified
+// against the commitment and the winners against the bets it acknowledged
+// holding that number before they are handed over. Every request uses a
+// new connection, so it may be called without an open session
+func (c *Client) QueryWinners(ctx context.Context) ([]string, error) {
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.configErr != nil {
+		return nil, c.configErr
+	}
+
+	if c.notified && c.upload.stopReader != nil {
+		err := c.awaitDraw(ctx)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == ErrDrawMissed {
+			c.drawMissed()
+			return nil, err
+		}
+	}
+
+	winners, err := c.queryWinners(ctx, c.config.Round)
+	if err == ErrDrawMissed {
+		c.drawMissed()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v", len(winners))
+
+	if c.commitment != "" {
+		if err := c.verifyDraw(ctx, winners); err != nil {
+			return nil, err
+		}
+	}
+	if c.results != nil {
+		if err := c.publishWinners(ctx, winners); err != nil {
+			return nil, err
+		}
+	}
+	return winners, nil
+}
+
+// verifyDraw Checks the winning number the server reveals once the draw
+// took place is the one it committed to when the agency registered, and
+// that the winners are exactly the acknowledged bets holding that number
+func (c *Client) verifyDraw(ctx context.Context, winners []string) error {
+	number, err := c.revealDraw(ctx)
+	if err == nil && !sameDocuments(winners, c.committedBets[number]) {
+		err = ErrWinnersMismatch
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.log.Errorf("action: verificar_sorteo | result: fail | client_id: %v | round: %v | error: %v",
+			c.config.ID,
+			c.config.Round,
+			err,
+		)
+		return err
+	}
+	c.log.Infof("action: verificar_sorteo | result: success | client_id: %v | round: %v | numero: %v",
+		c.config.ID,
+		c.config.Round,
+		number,
+	)
+	return nil
+}
+
+// revealDraw Asks for the seed of the draw and returns the winning number
+// once it is verified against the commitment
+func (c *Client) revealDraw(ctx context.Context) (string, error) {
+	msg, err := c.request(ctx, codec.RevealRequestMessage{Round: c.config.Round})
+	if err != nil {
+		return "", err
+	}
+	reveal, ok := msg.(codec.RevealMessage)
+	if !ok {
+		return "", &UnexpectedMessageError{Got: msg.Type(), Want: codec.TypeReveal}
+	}
+	if err := draw.Verify(c.commitment, reveal.Seed, reveal.Number); err != nil {
+		return "", err
+	}
+	return reveal.Number, nil
+}
+
+// recordCommittedBets Keeps the documents of bets the server acknowledged
+// by their number, written the way winning numbers are
+func (c *Client) recordCommittedBets(bets []codec.BetMessage) {
+	if c.committedBets == nil {
+		c.committedBets = make(map[string][]string)
+	}
+	for _, bet := range bets {
+		number := bet.Number
+		if n, err := strconv.ParseUint(number, 10, 64); err == nil {
+			number = strconv.FormatUint(n, 10)
+		}
+		c.committedBets[number] = append(c.committedBets[number], bet.Document)
+	}
+}
+
+// sameDocuments Whether both lists hold the same documents the same amount
+// of times, in any order
+func sameDocuments(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, document := range a {
+		counts[document]++
+	}
+	for _, document := range b {
+		if counts[document] == 0 {
+			return false
+		}
+		counts[document]--
+	}
+	return true
+}
+
+// QueryRoundWinners Returns the documents of the winners of the agency in
+// the draw of a round other than the configured one, such as a past round.
+// They are not handed to the result sink. It waits like QueryWinners while
+// the draw of the round is pending, and returns ErrUnknownRound when the
+// server does not know the round
+func (c *Client) QueryRoundWinners(ctx context.Context, round uint64) ([]string, error) {
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.configErr != nil {
+		return nil, c.configErr
+	}
+
+	winners, err := c.queryWinners(ctx, round)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Infof("action: consulta_ganadores | result: success | client_id: %v | round: %v | cant_ganadores: %v",
+		c.config.ID,
+		round,
+		len(winners),
+	)
+	return winners, nil
+}
+
+// awaitDraw Waits for the server to push the draw through the connection
+// the bets were sent through, which is closed afterwards. Any failure,
+// such as a server that does not push the draw, falls back to polling
+func (c *Client) awaitDraw(ctx context.Context) error {
+	defer c.upload.close()
+
+	c.log.Debugf("action: draw_complete | result: in_progress | client_id: %v | timeout: %v", c.config.ID, c.config.DrawTimeout)
+	if err := c.upload.awaitDraw(ctx, c.config.DrawTimeout); err != nil {
+		if ctx.Err() == nil && err != ErrDrawMissed {
+			c.log.Warningf("action: draw_complete | result: fail | client_id: %v | error: %v | fallback: polling",
+				c.config.ID,
+				err,
+			)
+		}
+		return err
+	}
+	c.log.Infof("action: draw_complete | result: success | client_id: %v", c.config.ID)
+	return nil
+}
+
+// drawMissed Logs that the draw took place without the agency
+func (c *Client) drawMissed() {
+	c.log.Warningf("action: sorteo | result: fail | client_id: %v | error: %v", c.config.ID, ErrDrawMissed)
+}
+
+// Close Ends the session closing its connection, the capture and the
+// metrics server. Only failing to write the capture is returned
+func (c *Client) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if c.upload != nil {
+		c.upload.close()
+	}
+	if c.stopProgress != nil {
+		c.stopProgress()
+	}
+	if c.metricsServer != nil {
+		c.metricsServer.Close()
+	}
+	if c.capture != nil {
+		return c.stopCapture()
+	}
+	return nil
+}
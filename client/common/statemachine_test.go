@@ -0,0 +1,419 @@
+package common
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/clock"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/draw"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+// scriptedClient Client whose every exchange is attended by the test
+// through an in-memory transport
+type scriptedClient struct {
+	t         *testing.T
+	transport *lotterytest.Transport
+	done      chan error
+	cancel    context.CancelFunc
+}
+
+func startScriptedClient(t *testing.T, config ClientConfig, options ...Option) *scriptedClient {
+	t.Helper()
+	config.ServerAddress = "memory://lottery"
+
+	transport := lotterytest.NewTransport()
+	client := NewClient(config, append(options, WithTransport("memory", transport))...)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scriptedClient{t: t, transport: transport, done: make(chan error, 1), cancel: cancel}
+	go func() { s.done <- client.StartClientLoop(ctx) }()
+	t.Cleanup(cancel)
+	return s
+}
+
+func (s *scriptedClient) accept() *lotterytest.Conn {
+	s.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := s.transport.Accept(ctx)
+	if err != nil {
+		s.t.Fatalf("client did not connect: %v", err)
+	}
+	return conn
+}
+
+// acceptUpload Accepts the connection the bets are sent through and
+// registers the agency
+func (s *scriptedClient) acceptUpload() *lotterytest.Conn {
+	s.t.Helper()
+	conn := s.accept()
+	receive(s.t, conn, codec.RegisterMessage{Agency: "1"})
+	send(s.t, conn, codec.RegisteredMessage{Status: codec.RegisterOK})
+	return conn
+}
+
+func (s *scriptedClient) wait() error {
+	s.t.Helper()
+	select {
+	case err := <-s.done:
+		return err
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("client did not finish")
+		return nil
+	}
+}
+
+func receiveBatch(t *testing.T, conn *lotterytest.Conn, id uint64, bets int) {
+	t.Helper()
+	batch, err := conn.ReceiveBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.ID != id || len(batch.Bets) != bets {
+		t.Fatalf("received batch %d with %d bets, want batch %d with %d bets", batch.ID, len(batch.Bets), id, bets)
+	}
+}
+
+func receive(t *testing.T, conn *lotterytest.Conn, want codec.Message) {
+	t.Helper()
+	msg, err := conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Fatalf("received %#v, want %#v", msg, want)
+	}
+}
+
+func send(t *testing.T, conn *lotterytest.Conn, msg codec.Message) {
+	t.Helper()
+	if err := conn.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// finishUpload Expects the end of the bets followed by the client closing
+// the connection
+func finishUpload(t *testing.T, conn *lotterytest.Conn) {
+	t.Helper()
+	receive(t, conn, codec.EndOfBetsMessage{Agency: "1"})
+	if _, err := conn.Receive(); err != io.EOF {
+		t.Fatalf("connection still open after the end of the bets: %v", err)
+	}
+}
+
+// answerWinners Attends a winners request on a new connection
+func answerWinners(t *testing.T, s *scriptedClient, reply codec.Message) {
+	t.Helper()
+	conn := s.accept()
+	defer conn.Close()
+	receive(t, conn, codec.WinnersRequestMessage{Agency: "1"})
+	send(t, conn, reply)
+}
+
+func TestClientStateMachineUploadsAndWaitsForDraw(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BetsFile: manyBetsFile(t, 3)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	receiveBatch(t, conn, 2, 1)
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckOK})
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersPendingMessage{})
+	answerWinners(t, s, codec.WinnersPendingMessage{})
+	answerWinners(t, s, codec.WinnersNotificationMessage{Documents: []string{"30000001"}})
+
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineRetriesBusyBatches(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchMaxRetries: 1, BetsFile: manyBetsFile(t, 4)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckBusy})
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+
+	// The second batch runs out of retries and is given up
+	receiveBatch(t, conn, 2, 2)
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckBusy})
+	receiveBatch(t, conn, 2, 2)
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckBusy})
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineReconnectsAndResends(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchWindow: 2, BatchMaxRetries: 1, BetsFile: manyBetsFile(t, 4)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	receiveBatch(t, conn, 2, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	conn.Close()
+
+	// Only the unacknowledged batch is sent again
+	conn = s.acceptUpload()
+	receiveBatch(t, conn, 2, 2)
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckOK})
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineShutsDownWhileWaitingForAck(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, BetsFile: manyBetsFile(t, 4)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	s.cancel()
+
+	if err := s.wait(); err != context.Canceled {
+		t.Fatalf("StartClientLoop() = %v, want %v", err, context.Canceled)
+	}
+	if _, err := conn.Receive(); err != io.EOF {
+		t.Errorf("connection still open after the shutdown: %v", err)
+	}
+}
+
+func TestClientStateMachinePacesRequestsWithTheClock(t *testing.T) {
+	// A whole hour between requests only takes as long as advancing the clock
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, LoopPeriod: time.Hour, BetsFile: manyBetsFile(t, 4)}
+	s := startScriptedClient(t, config, WithClock(fake))
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+	receiveBatch(t, conn, 2, 2)
+	send(t, conn, codec.AckMessage{BatchID: 2, Status: codec.AckOK})
+	finishUpload(t, conn)
+
+	answerWinners(t, s, codec.WinnersPendingMessage{})
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineWaitsForTheDrawPush(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, DrawTimeout: time.Hour, BetsFile: manyBetsFile(t, 2)}
+	s := startScriptedClient(t, config)
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	receive(t, conn, codec.EndOfBetsMessage{Agency: "1"})
+	send(t, conn, codec.DrawCompleteMessage{})
+	if _, err := conn.Receive(); err != io.EOF {
+		t.Fatalf("connection still open after the draw: %v", err)
+	}
+
+	// The winners are asked for once, as the draw already took place
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachinePollsWhenTheDrawIsNotPushed(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, DrawTimeout: time.Minute, BetsFile: manyBetsFile(t, 2)}
+	s := startScriptedClient(t, config, WithClock(fake))
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	receive(t, conn, codec.EndOfBetsMessage{Agency: "1"})
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	if _, err := conn.Receive(); err != io.EOF {
+		t.Fatalf("connection still open after the draw timeout: %v", err)
+	}
+
+	answerWinners(t, s, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineStopsWhenTheServerRefusesTheAgency(t *testing.T) {
+	tests := []struct {
+		status codec.RegisterStatus
+		want   error
+	}{
+		{status: codec.RegisterUnknown, want: ErrNotInRoster},
+		{status: codec.RegisterClosed, want: ErrDrawMissed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status.String(), func(t *testing.T) {
+			config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchMaxRetries: 3, BetsFile: manyBetsFile(t, 2)}
+			s := startScriptedClient(t, config)
+
+			// The refusal is final, so the client neither retries nor
+			// sends any bet
+			conn := s.accept()
+			receive(t, conn, codec.RegisterMessage{Agency: "1"})
+			send(t, conn, codec.RegisteredMessage{Status: tt.status})
+			if _, err := conn.Receive(); err != io.EOF {
+				t.Fatalf("connection still open after the refusal: %v", err)
+			}
+			if err := s.wait(); err != tt.want {
+				t.Fatalf("StartClientLoop() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStateMachineLogsTheDrawTakenWithoutIt(t *testing.T) {
+	logger := &recordingLogger{}
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, DrawTimeout: time.Hour, BetsFile: manyBetsFile(t, 2)}
+	s := startScriptedClient(t, config, WithLogger(logger))
+
+	conn := s.acceptUpload()
+	receiveBatch(t, conn, 1, 2)
+	send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+	receive(t, conn, codec.EndOfBetsMessage{Agency: "1"})
+	send(t, conn, codec.DrawMissedMessage{})
+
+	// There are no winners to ask for once the draw went on without it
+	if err := s.wait(); err != ErrDrawMissed {
+		t.Fatalf("StartClientLoop() = %v, want %v", err, ErrDrawMissed)
+	}
+	if !logger.contains("sorteo") {
+		t.Error("the missed draw was not logged")
+	}
+}
+
+func TestClientStateMachineScopesTheSessionToTheRound(t *testing.T) {
+	config := ClientConfig{ID: "1", BatchMaxAmount: 2, Round: 7, BetsFile: manyBetsFile(t, 2)}
+	s := startScriptedClient(t, config)
+
+	conn := s.accept()
+	receive(t, conn, codec.RegisterMessage{Agency: "1", Round: 7})
+	send(t, conn, codec.RegisteredMessage{Status: codec.RegisterOK})
+	batch, err := conn.ReceiveBatch()
+	if err != nil || batch.Round != 7 {
+		t.Fatalf("received batch of round %d, %v, want round 7", batch.Round, err)
+	}
+	send(t, conn, codec.AckMessage{BatchID: batch.ID, Status: codec.AckOK})
+	receive(t, conn, codec.EndOfBetsMessage{Agency: "1", Round: 7})
+	if _, err := conn.Receive(); err != io.EOF {
+		t.Fatalf("connection still open after the end of the bets: %v", err)
+	}
+
+	winners := s.accept()
+	defer winners.Close()
+	receive(t, winners, codec.WinnersRequestMessage{Agency: "1", Round: 7})
+	send(t, winners, codec.WinnersNotificationMessage{})
+	if err := s.wait(); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+}
+
+func TestClientStateMachineRejectsATamperedDraw(t *testing.T) {
+	seed := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+	committed := codec.RevealMessage{Number: draw.WinningNumber(seed), Seed: seed}
+	tests := []struct {
+		name    string
+		winners []string
+		reveal  codec.RevealMessage
+		want    error
+	}{
+		{name: "committed draw", reveal: committed},
+		{name: "another seed", reveal: codec.RevealMessage{Number: draw.WinningNumber(other), Seed: other}, want: draw.ErrCommitmentMismatch},
+		{name: "another number", reveal: codec.RevealMessage{Number: draw.WinningNumber(seed) + "1", Seed: seed}, want: draw.ErrNumberMismatch},
+		// None of the bets holds the revealed number
+		{name: "winners that did not bet the number", winners: []string{"30000000"}, reveal: committed, want: ErrWinnersMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			config := ClientConfig{ID: "1", BatchMaxAmount: 2, BetsFile: manyBetsFile(t, 2)}
+			s := startScriptedClient(t, config, WithLogger(logger))
+
+			conn := s.accept()
+			receive(t, conn, codec.RegisterMessage{Agency: "1"})
+			send(t, conn, codec.RegisteredMessage{Status: codec.RegisterOK, Commitment: draw.Commitment(seed)})
+			receiveBatch(t, conn, 1, 2)
+			send(t, conn, codec.AckMessage{BatchID: 1, Status: codec.AckOK})
+			finishUpload(t, conn)
+			answerWinners(t, s, codec.WinnersNotificationMessage{Documents: tt.winners})
+
+			reveal := s.accept()
+			defer reveal.Close()
+			receive(t, reveal, codec.RevealRequestMessage{})
+			send(t, reveal, tt.reveal)
+
+			if err := s.wait(); err != tt.want {
+				t.Fatalf("StartClientLoop() = %v, want %v", err, tt.want)
+			}
+			if !logger.contains("verificar_sorteo") {
+				t.Error("the verification of the draw was not logged")
+			}
+		})
+	}
+}
+
+func TestClientStateMachineRefusesAChangedCommitment(t *testing.T) {
+	commitment := draw.Commitment(strings.Repeat("ab", 32))
+	tests := []struct {
+		name  string
+		reply codec.RegisteredMessage
+	}{
+		{name: "another commitment", reply: codec.RegisteredMessage{Status: codec.RegisterOK, Commitment: draw.Commitment(strings.Repeat("cd", 32))}},
+		{name: "no commitment", reply: codec.RegisteredMessage{Status: codec.RegisterOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ClientConfig{ID: "1", BatchMaxAmount: 2, BatchMaxRetries: 1, BetsFile: manyBetsFile(t, 2)}
+			s := startScriptedClient(t, config)
+
+			conn := s.accept()
+			receive(t, conn, codec.RegisterMessage{Agency: "1"})
+			send(t, conn, codec.RegisteredMessage{Status: codec.RegisterOK, Commitment: commitment})
+			receiveBatch(t, conn, 1, 2)
+			conn.Close()
+
+			// The registration after the reconnection must answer the
+			// draw the first one committed to
+			conn = s.accept()
+			defer conn.Close()
+			receive(t, conn, codec.RegisterMessage{Agency: "1"})
+			send(t, conn, tt.reply)
+			if err := s.wait(); err != ErrCommitmentChanged {
+				t.Fatalf("StartClientLoop() = %v, want %v", err, ErrCommitmentChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package synthetic generates random bets in the format of the agency files,
+// so the client can be exercised without the datasets provided by the course.
+package synthetic
+
+import (
+	"encoding/csv"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+var firstNames = []string{
+	"Santiago", "Lionel", "María", "Antonella", "Nicolás", "Martina", "Pilar",
+	"Joaquín", "Sofía", "Agustín", "Valentina", "Tomás", "Lucía", "Julián",
+	"Camila", "Matías", "Florencia", "Germán", "Inés", "Ramón",
+}
+
+var lastNames = []string{
+	"Lorca", "Leiva", "Mamani", "Huarte", "Peña", "González", "Rodríguez",
+	"Fernández", "López", "Martínez", "Gómez", "Pérez", "Sánchez", "Romero",
+	"Díaz", "Álvarez", "Acuña", "Muñoz", "Benítez", "Ibáñez",
+}
+
+// LotteryWinnerNumber Number the server considers the winner of the draw
+const LotteryWinnerNumber = 7574
+
+// UniformWinners WinnerRatio that picks numbers uniformly, so winners are
+// as rare as in a real draw
+const UniformWinners = -1
+
+// documents Range of the generated documents
+const (
+	minDocument    = 10000000
+	documentsRange = 40000000
+)
+
+// birthdates Range of the generated birthdates
+var (
+	minBirthdate = time.Date(1930, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxBirthdate = time.Date(2005, time.December, 31, 0, 0, 0, 0, time.UTC)
+)
+
+// Generator Produces random rows of an agency file. Generators created
+// with the same seed and settings produce the same rows. Documents are
+// drawn without replacement, so no two rows of a generator share one
+type Generator struct {
+	// WinnerRatio Fraction of the rows that bet on WinnerNumber. Zero
+	// produces no winners and UniformWinners, the default, picks numbers
+	// uniformly
+	WinnerRatio float64
+	// WinnerNumber Number considered the winner of the draw
+	WinnerNumber int
+	// MalformedRatio Fraction of the rows that are not valid bets
+	MalformedRatio float64
+
+	rand      *rand.Rand
+	documents map[int]bool
+}
+
+// NewGenerator Initializes a generator from the given seed that only
+// produces valid bets
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		WinnerRatio:  UniformWinners,
+		WinnerNumber: LotteryWinnerNumber,
+		rand:         rand.New(rand.NewSource(seed)),
+		documents:    make(map[int]bool),
+	}
+}
+
+// Row Returns a bet as the fields of an agency file row: first name, last
+// name, document, birthdate and number. A fraction of the rows is malformed
+// when MalformedRatio is set
+func (g *Generator) Row() []string {
+	row := []string{
+		g.pick(firstNames) + " " + g.pick(firstNames),
+		g.pick(lastNames),
+		g.document(),
+		g.birthdate(),
+		g.number(),
+	}
+	if g.MalformedRatio > 0 && g.rand.Float64() < g.MalformedRatio {
+		return g.corrupt(row)
+	}
+	return row
+}
+
+// WriteRows Writes amount random rows to w in CSV format
+func (g *Generator) WriteRows(w io.Writer, amount int) error {
+	writer := csv.NewWriter(w)
+	for i := 0; i < amount; i++ {
+		if err := writer.Write(g.Row()); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteFile Creates the file at path holding amount random rows
+func (g *Generator) WriteFile(path string, amount int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := g.WriteRows(file, amount); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// document Picks a document no previous row of the generator holds
+func (g *Generator) document() string {
+	for {
+		document := minDocument + g.rand.Intn(documentsRange)
+		if !g.documents[document] {
+			g.documents[document] = true
+			return strconv.Itoa(document)
+		}
+	}
+}
+
+// number Picks the number of a bet honoring WinnerRatio
+func (g *Generator) number() string {
+	if g.WinnerRatio < 0 {
+		return strconv.Itoa(g.rand.Intn(10000))
+	}
+	if g.rand.Float64() < g.WinnerRatio {
+		return strconv.Itoa(g.WinnerNumber)
+	}
+	for {
+		if number := g.rand.Intn(10000); number != g.WinnerNumber {
+			return strconv.Itoa(number)
+		}
+	}
+}
+
+// corruptions Ways in which a valid row is turned into a malformed one
+var corruptions = []func(row []string) []string{
+	// Missing field
+	func(row []string) []string { return row[:4] },
+	// Empty name
+	func(row []string) []string { row[0] = ""; return row },
+	// Document that is not numeric
+	func(row []string) []string { row[2] = row[2][:4] + "-" + row[2][4:]; return row },
+	// Birthdate that is not in YYYY-MM-DD format
+	func(row []string) []string { row[3] = row[3][8:10] + "/" + row[3][5:7] + "/" + row[3][:4]; return row },
+	// Birthdate that does not exist
+	func(row []string) []string { row[3] = row[3][:5] + "02-30"; return row },
+	// Number that is not numeric
+	func(row []string) []string { row[4] = "N" + row[4]; return row },
+}
+
+// corrupt Applies a random corruption to a valid row
+func (g *Generator) corrupt(row []string) []string {
+	return corruptions[g.rand.Intn(len(corruptions))](row)
+}
+
+func (g *Generator) pick(values []string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+func (g *Generator) birthdate() string {
+	days := int(maxBirthdate.Sub(minBirthdate).Hours() / 24)
+	return minBirthdate.AddDate(0, 0, g.rand.Intn(days+1)).Format("2006-01-02")
+}
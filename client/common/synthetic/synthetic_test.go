@@ -0,0 +1,108 @@
+package synthetic
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+)
+
+func toBet(row []string) codec.BetMessage {
+	if len(row) != 5 {
+		return codec.BetMessage{}
+	}
+	return codec.BetMessage{
+		Agency:    "1",
+		FirstName: row[0],
+		LastName:  row[1],
+		Document:  row[2],
+		Birthdate: row[3],
+		Number:    row[4],
+	}
+}
+
+func TestGeneratorIsReproducible(t *testing.T) {
+	var first, second bytes.Buffer
+	if err := NewGenerator(42).WriteRows(&first, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewGenerator(42).WriteRows(&second, 100); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("generators with the same seed produced different rows")
+	}
+}
+
+func TestGeneratorRowsAreValidBets(t *testing.T) {
+	generator := NewGenerator(1)
+	for i := 0; i < 1000; i++ {
+		row := generator.Row()
+		if err := toBet(row).Validate(); err != nil {
+			t.Fatalf("row %v is not a valid bet: %v", row, err)
+		}
+	}
+}
+
+func TestGeneratorHonorsRatios(t *testing.T) {
+	generator := NewGenerator(7)
+	generator.WinnerRatio = 0.2
+	generator.MalformedRatio = 0.1
+
+	const rows = 10000
+	winners, malformed := 0, 0
+	for i := 0; i < rows; i++ {
+		row := generator.Row()
+		if toBet(row).Validate() != nil {
+			malformed++
+			continue
+		}
+		if row[4] == strconv.Itoa(LotteryWinnerNumber) {
+			winners++
+		}
+	}
+
+	// Winners are only counted among valid rows
+	if winners < 1600 || winners > 2000 {
+		t.Errorf("%d winners out of %d rows, want around 20%% of the valid ones", winners, rows)
+	}
+	if malformed < 800 || malformed > 1200 {
+		t.Errorf("%d malformed rows out of %d, want around 10%%", malformed, rows)
+	}
+}
+
+func TestCorruptionsProduceInvalidBets(t *testing.T) {
+	for i, corrupt := range corruptions {
+		row := corrupt(NewGenerator(int64(i)).Row())
+		if len(row) == 5 && toBet(row).Validate() == nil {
+			t.Errorf("corruption %d produced the valid row %v", i, row)
+		}
+		if reflect.DeepEqual(row, NewGenerator(int64(i)).Row()) {
+			t.Errorf("corruption %d did not change the row", i)
+		}
+	}
+}
+
+func TestGeneratorWithoutWinners(t *testing.T) {
+	generator := NewGenerator(3)
+	generator.WinnerRatio = 0
+	for i := 0; i < 20000; i++ {
+		if row := generator.Row(); row[4] == strconv.Itoa(LotteryWinnerNumber) {
+			t.Fatalf("row %v bets on the winner number", row)
+		}
+	}
+}
+
+func TestGeneratorDrawsEveryDocumentOnce(t *testing.T) {
+	generator := NewGenerator(5)
+	seen := make(map[string]bool)
+	for i := 0; i < 20000; i++ {
+		row := generator.Row()
+		if seen[row[2]] {
+			t.Fatalf("document %s generated twice", row[2])
+		}
+		seen[row[2]] = true
+	}
+}
@@ -0,0 +1,121 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Transport Opens connections to the server. Server endpoints are URLs
+// whose scheme selects the transport: tcp://host:port, unix:///path.sock
+// or tls://host:port. Endpoints without scheme use TCP
+type Transport interface {
+	// Dial Connects to the address of the endpoint, which is what follows
+	// the scheme
+	Dial(ctx context.Context, address string) (net.Conn, error)
+}
+
+// ErrUnsupportedScheme Returned when no transport handles the scheme of
+// an endpoint
+var ErrUnsupportedScheme = errors.New("unsupported endpoint scheme")
+
+// splitEndpoint Returns the scheme and the address of the endpoint
+func splitEndpoint(endpoint string) (string, string) {
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		return endpoint[:i], endpoint[i+len("://"):]
+	}
+	return "tcp", endpoint
+}
+
+// newTransports Returns the transports available by default, which open
+// their connections through dialer. TCP and TLS endpoints are resolved
+// through addresses
+func newTransports(addresses *addressCache, dialer Dialer, tlsConfig *tls.Config) map[string]Transport {
+	tcp := &tcpTransport{addresses: addresses, dialer: dialer}
+	return map[string]Transport{
+		"tcp":  tcp,
+		"unix": unixTransport{dialer: dialer},
+		"tls":  &tlsTransport{tcp: tcp, config: tlsConfig},
+	}
+}
+
+// dialEndpoint Connects to the endpoint through the transport of its scheme
+func dialEndpoint(ctx context.Context, transports map[string]Transport, endpoint string) (net.Conn, error) {
+	scheme, address := splitEndpoint(endpoint)
+	transport, ok := transports[scheme]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnsupportedScheme, "%q", scheme)
+	}
+	return transport.Dial(ctx, address)
+}
+
+// tcpTransport Connects to the first address the endpoint resolves to that
+// accepts the connection
+type tcpTransport struct {
+	addresses *addressCache
+	dialer    Dialer
+}
+
+func (t *tcpTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	addresses, err := t.addresses.addresses(ctx, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not resolve %s", address)
+	}
+
+	for _, resolved := range addresses {
+		var conn net.Conn
+		conn, err = t.dialer.DialContext(ctx, "tcp", resolved)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// unixTransport Connects to a Unix domain socket given by its path
+type unixTransport struct {
+	dialer Dialer
+}
+
+func (t unixTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	return t.dialer.DialContext(ctx, "unix", address)
+}
+
+// tlsTransport Performs a TLS handshake over a TCP connection. The server
+// certificate is checked against the host of the endpoint unless the
+// configuration sets a server name
+type tlsTransport struct {
+	tcp    *tcpTransport
+	config *tls.Config
+}
+
+func (t *tlsTransport) Dial(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := t.tcp.Dial(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{}
+	if t.config != nil {
+		config = t.config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = address
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
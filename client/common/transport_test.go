@@ -0,0 +1,147 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+// selfSignedCertificate Returns a certificate valid for 127.0.0.1 along with
+// the pool of roots that trusts it
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lottery"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+func TestClientTransports(t *testing.T) {
+	certificate, roots := selfSignedCertificate(t)
+
+	tests := []struct {
+		name   string
+		listen func(t *testing.T) (net.Listener, string)
+	}{
+		{
+			name: "unix",
+			listen: func(t *testing.T) (net.Listener, string) {
+				path := filepath.Join(t.TempDir(), "server.sock")
+				listener, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return listener, "unix://" + path
+			},
+		},
+		{
+			name: "tls",
+			listen: func(t *testing.T) (net.Listener, string) {
+				listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return listener, "tls://" + listener.Addr().String()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startServer(t, 1)
+			listener, endpoint := tt.listen(t)
+			server.Serve(listener)
+
+			config := testConfig(server, manyBetsFile(t, 4))
+			config.ServerAddress = endpoint
+			config.TLSConfig = &tls.Config{RootCAs: roots}
+			config.WaitTimeout = time.Second
+			if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+				t.Fatalf("StartClientLoop() = %v", err)
+			}
+			assertBetsStoredOnce(t, server, 4)
+		})
+	}
+}
+
+func TestClientOverInMemoryTransport(t *testing.T) {
+	server := startServer(t, 1)
+	config := testConfig(server, manyBetsFile(t, 4))
+	config.ServerAddress = "memory://lottery"
+	config.BatchWindow = 2
+
+	// Every connection the client dials is attended by the server
+	transport := lotterytest.NewTransport()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			conn, err := transport.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+
+	client := NewClient(config, WithTransport("memory", transport))
+	if err := client.StartClientLoop(context.Background()); err != nil {
+		t.Fatalf("StartClientLoop() = %v", err)
+	}
+	assertBetsStoredOnce(t, server, 4)
+}
+
+func TestTLSTransportRejectsUnknownCertificate(t *testing.T) {
+	certificate, _ := selfSignedCertificate(t)
+	server := startServer(t, 1)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Serve(listener)
+
+	// The certificate is not among the system roots
+	if _, err := Ping(context.Background(), "tls://"+listener.Addr().String(), time.Second, nil); err == nil {
+		t.Error("Ping() trusted a self-signed certificate")
+	}
+}
+
+func TestDialEndpointRejectsUnknownScheme(t *testing.T) {
+	_, err := dialEndpoint(context.Background(), newTransports(newAddressCache(nil, time.Minute), &net.Dialer{}, nil), "quic://server:12345")
+	if errors.Cause(err) != ErrUnsupportedScheme {
+		t.Errorf("dialEndpoint() = %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
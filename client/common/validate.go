@@ -0,0 +1,164 @@
+package common
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+)
+
+// betsFileName Name agency files must have, capturing the agency ID
+var betsFileName = regexp.MustCompile(`^agency-([0-9]+)\.csv$`)
+
+// Severity How much an issue keeps an agency file from being uploaded
+type Severity string
+
+const (
+	// SeverityError The bet, or the whole file, would be rejected
+	SeverityError Severity = "error"
+	// SeverityWarning Worth checking, but the server stores the bet anyway
+	SeverityWarning Severity = "warning"
+)
+
+// ValidationIssue A problem found in an agency file. Line is zero for
+// problems that concern the whole file
+type ValidationIssue struct {
+	Line     int      `json:"line"`
+	Field    string   `json:"field,omitempty"`
+	Reason   string   `json:"reason"`
+	Severity Severity `json:"severity"`
+}
+
+// ValidationReport Result of validating an agency file without sending it
+type ValidationReport struct {
+	File   string            `json:"file"`
+	Agency string            `json:"agency"`
+	Rows   int               `json:"rows"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Valid Whether the file can be uploaded without any bet being rejected.
+// Warnings do not make the file invalid
+func (r ValidationReport) Valid() bool {
+	return r.count(SeverityError) == 0
+}
+
+// count Amount of issues of the given severity
+func (r ValidationReport) count(severity Severity) int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidateBetsFile Applies the rules of the bet codec to every row of the
+// agency file and also warns about documents repeated within the file,
+// which the server stores as one person may place several bets. The
+// agency is taken from the file name, which must match the given agency
+// unless it is empty. Only failing to read the file is returned as error
+func ValidateBetsFile(path string, agency string) (ValidationReport, error) {
+	report := ValidationReport{File: path, Agency: agency, Issues: []ValidationIssue{}}
+
+	match := betsFileName.FindStringSubmatch(filepath.Base(path))
+	switch {
+	case match == nil:
+		report.addIssue(0, "agency", "file name does not follow the agency-{N}.csv format")
+	case agency == "":
+		report.Agency = match[1]
+	case agency != match[1]:
+		report.addIssue(0, "agency", fmt.Sprintf("file belongs to agency %s but agency %s was expected", match[1], agency))
+	}
+	rowAgency := report.Agency
+	if rowAgency == "" {
+		// Rows can still be checked even if the agency is unknown
+		rowAgency = "0"
+	}
+
+	reader, err := NewBetReader(path, rowAgency)
+	if err != nil {
+		return report, err
+	}
+	defer reader.Close()
+
+	documents := make(map[string]int)
+	for {
+		bet, err := reader.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		report.Rows++
+		line := reader.Line()
+
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return report, err
+			}
+			report.addIssue(line, "", parseErr.Err.Error())
+			continue
+		}
+
+		if err := bet.toMessage().Validate(); err != nil {
+			if fieldErr, ok := err.(*codec.FieldError); ok {
+				report.addIssue(line, fieldErr.Field, fieldErr.Reason)
+			} else {
+				report.addIssue(line, "", err.Error())
+			}
+			continue
+		}
+
+		if first, ok := documents[bet.Document]; ok {
+			report.addWarning(line, "document", fmt.Sprintf("%s already used at line %d", bet.Document, first))
+			continue
+		}
+		documents[bet.Document] = line
+	}
+}
+
+func (r *ValidationReport) addIssue(line int, field string, reason string) {
+	r.Issues = append(r.Issues, ValidationIssue{Line: line, Field: field, Reason: reason, Severity: SeverityError})
+}
+
+func (r *ValidationReport) addWarning(line int, field string, reason string) {
+	r.Issues = append(r.Issues, ValidationIssue{Line: line, Field: field, Reason: reason, Severity: SeverityWarning})
+}
+
+// WriteText Writes the report in a human readable format, one issue per line
+func (r ValidationReport) WriteText(w io.Writer) error {
+	for _, issue := range r.Issues {
+		location := fmt.Sprintf("%s:%d", r.File, issue.Line)
+		if issue.Field != "" {
+			location += ": " + issue.Field
+		}
+		if issue.Severity == SeverityWarning {
+			location += ": warning"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", location, issue.Reason); err != nil {
+			return err
+		}
+	}
+
+	result := "valid"
+	if !r.Valid() {
+		result = fmt.Sprintf("invalid, %d issues", r.count(SeverityError))
+	}
+	if warnings := r.count(SeverityWarning); warnings > 0 {
+		result += fmt.Sprintf(", %d warnings", warnings)
+	}
+	_, err := fmt.Fprintf(w, "%s: agency %s, %d rows, %s\n", r.File, r.Agency, r.Rows, result)
+	return err
+}
+
+// WriteJSON Writes the report as an indented JSON document
+func (r ValidationReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
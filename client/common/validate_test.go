@@ -0,0 +1,119 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateBetsFileReportsEveryIssue(t *testing.T) {
+	path := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,17/03/1999,8676",
+		"Nicolás,Peña,30904465,1994-03-16,7574",
+		"Martina Pilar,Mamani,29369913,1989-12-05",
+		"Joaquín,Acuña,2936991X,1989-12-05,12",
+	)
+
+	report, err := ValidateBetsFile(path, "")
+	if err != nil {
+		t.Fatalf("ValidateBetsFile() = %v", err)
+	}
+
+	if report.Agency != "1" || report.Rows != 5 {
+		t.Errorf("agency %q, rows %d, want \"1\", 5", report.Agency, report.Rows)
+	}
+	var lines []int
+	var fields []string
+	var severities []Severity
+	for _, issue := range report.Issues {
+		lines = append(lines, issue.Line)
+		fields = append(fields, issue.Field)
+		severities = append(severities, issue.Severity)
+	}
+	if want := []int{2, 3, 4, 5}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("issues at lines %v, want %v", lines, want)
+	}
+	if want := []string{"birthdate", "document", "", "document"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("issues on fields %q, want %q", fields, want)
+	}
+	// Repeated documents are only warned about
+	if want := []Severity{SeverityError, SeverityWarning, SeverityError, SeverityError}; !reflect.DeepEqual(severities, want) {
+		t.Errorf("issues of severities %q, want %q", severities, want)
+	}
+}
+
+func TestValidateBetsFileAcceptsRepeatedDocuments(t *testing.T) {
+	path := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Santiago Lionel,Lorca,30904465,1999-03-17,8676",
+	)
+
+	report, err := ValidateBetsFile(path, "")
+	if err != nil {
+		t.Fatalf("ValidateBetsFile() = %v", err)
+	}
+	if !report.Valid() || len(report.Issues) != 1 {
+		t.Errorf("valid %v, issues %+v, want a valid file with a single warning", report.Valid(), report.Issues)
+	}
+
+	var text strings.Builder
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(text.String(), "2 rows, valid, 1 warnings\n") {
+		t.Errorf("text report = %q, want it to count the warning", text.String())
+	}
+}
+
+func TestValidateBetsFileChecksAgencyAgainstFileName(t *testing.T) {
+	path := writeBetsFile(t, "Santiago Lionel,Lorca,30904465,1999-03-17,7574")
+
+	report, err := ValidateBetsFile(path, "2")
+	if err != nil {
+		t.Fatalf("ValidateBetsFile() = %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Line != 0 || report.Issues[0].Field != "agency" {
+		t.Errorf("issues = %+v, want a single agency issue", report.Issues)
+	}
+
+	renamed := filepath.Join(filepath.Dir(path), "bets.csv")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	report, err = ValidateBetsFile(renamed, "")
+	if err != nil {
+		t.Fatalf("ValidateBetsFile() = %v", err)
+	}
+	if report.Valid() {
+		t.Error("file not named after its agency was reported valid")
+	}
+}
+
+func TestValidationReportFormats(t *testing.T) {
+	report := ValidationReport{
+		File:   "agency-1.csv",
+		Agency: "1",
+		Rows:   2,
+		Issues: []ValidationIssue{{Line: 2, Field: "number", Reason: `"N12" is not numeric`, Severity: SeverityError}},
+	}
+
+	var text strings.Builder
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	want := "agency-1.csv:2: number: \"N12\" is not numeric\nagency-1.csv: agency 1, 2 rows, invalid, 1 issues\n"
+	if text.String() != want {
+		t.Errorf("text report = %q, want %q", text.String(), want)
+	}
+
+	var json strings.Builder
+	if err := report.WriteJSON(&json); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(json.String(), `"line": 2`) || !strings.Contains(json.String(), `"severity": "error"`) {
+		t.Errorf("JSON report does not hold the issue:\n%s", json.String())
+	}
+}
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Winner A winning bet of the agency joined back with its row of the
+// agency file
+type Winner struct {
+	Document  string `json:"document"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Birthdate string `json:"birthdate"`
+	Number    string `json:"number"`
+}
+
+// winnersHeader Header of the exported CSV file
+var winnersHeader = []string{"document", "first_name", "last_name", "birthdate", "number"}
+
+// findWinners Reads the agency file again looking for the bets placed with
+// the winning documents. The server reports a document once per winning
+// bet, so a document joins as many rows as times it was reported, taken in
+// file order. Reported documents that are not found in the file are kept
+// with their remaining fields empty
+func findWinners(path string, agency string, documents []string) ([]Winner, error) {
+	pending := make(map[string]int, len(documents))
+	for _, document := range documents {
+		pending[document]++
+	}
+
+	reader, err := NewBetReader(path, agency)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var winners []Winner
+	for {
+		bet, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, malformed := err.(*csv.ParseError); malformed {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pending[bet.Document] > 0 {
+			pending[bet.Document]--
+			winners = append(winners, Winner{
+				Document:  bet.Document,
+				FirstName: bet.FirstName,
+				LastName:  bet.LastName,
+				Birthdate: bet.Birthdate,
+				Number:    bet.Number,
+			})
+		}
+	}
+
+	for _, document := range documents {
+		if pending[document] > 0 {
+			pending[document]--
+			winners = append(winners, Winner{Document: document})
+		}
+	}
+	return winners, nil
+}
+
+// winnersFormat Returns the format the winners are exported with. When no
+// format is configured it is deduced from the extension of the file
+func winnersFormat(path string, format string) (string, error) {
+	if format == "" {
+		format = "csv"
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			format = "json"
+		}
+	}
+	format = strings.ToLower(format)
+	if format != "csv" && format != "json" {
+		return "", errors.Errorf("unknown winners format %q", format)
+	}
+	return format, nil
+}
+
+// writeWinners Writes the winners to path in the given format. The file is
+// written under a temporary name and then renamed, so readers never see a
+// partial export. The export is readable by every user
+func writeWinners(path string, format string, winners []Winner) error {
+	format, err := winnersFormat(path, format)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if format == "json" {
+		err = encodeWinnersJSON(file, winners)
+	} else {
+		err = encodeWinnersCSV(file, winners)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// os.CreateTemp only lets the owner read the file
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func encodeWinnersJSON(w io.Writer, winners []Winner) error {
+	if winners == nil {
+		winners = []Winner{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(winners)
+}
+
+func encodeWinnersCSV(w io.Writer, winners []Winner) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(winnersHeader); err != nil {
+		return err
+	}
+	for _, winner := range winners {
+		record := []string{winner.Document, winner.FirstName, winner.LastName, winner.Birthdate, winner.Number}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// winnersFile Result sink that joins the winning documents with the agency
+// file and writes them to path
+type winnersFile struct {
+	path     string
+	format   string
+	betsFile string
+	agency   string
+}
+
+func (f *winnersFile) Winners(ctx context.Context, documents []string) error {
+	winners, err := findWinners(f.betsFile, f.agency, documents)
+	if err != nil {
+		return err
+	}
+	return writeWinners(f.path, f.format, winners)
+}
+
+// publishWinners Hands the winning documents to the result sink
+func (c *Client) publishWinners(ctx context.Context, documents []string) error {
+	if err := c.results.Winners(ctx, documents); err != nil {
+		c.log.Errorf("action: export_winners | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	c.log.Infof("action: export_winners | result: success | client_id: %v | cantidad: %v", c.config.ID, len(documents))
+	return nil
+}
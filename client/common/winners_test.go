@@ -0,0 +1,142 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClientLoopExportsWinners(t *testing.T) {
+	rows := []string{
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Maria Antonella,Leiva,24260718,1987-08-01,8676",
+		"Nicolás,Peña,27726965,1994-03-16,7574",
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{
+			file: "winners.csv",
+			want: "document,first_name,last_name,birthdate,number\n" +
+				"30904465,Santiago Lionel,Lorca,1999-03-17,7574\n" +
+				"27726965,Nicolás,Peña,1994-03-16,7574\n",
+		},
+		{
+			file: "winners.json",
+			want: `[
+  {
+    "document": "30904465",
+    "first_name": "Santiago Lionel",
+    "last_name": "Lorca",
+    "birthdate": "1999-03-17",
+    "number": "7574"
+  },
+  {
+    "document": "27726965",
+    "first_name": "Nicolás",
+    "last_name": "Peña",
+    "birthdate": "1994-03-16",
+    "number": "7574"
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			server := startServer(t, 1)
+			config := testConfig(server, writeBetsFile(t, rows...))
+			config.WinnersOutput = filepath.Join(t.TempDir(), tt.file)
+
+			if err := NewClient(config).StartClientLoop(context.Background()); err != nil {
+				t.Fatalf("StartClientLoop() = %v", err)
+			}
+			got, err := os.ReadFile(config.WinnersOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("export =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindWinnersKeepsUnknownDocuments(t *testing.T) {
+	path := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"broken row",
+	)
+
+	winners, err := findWinners(path, "1", []string{"30904465", "11111111"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Winner{
+		{Document: "30904465", FirstName: "Santiago Lionel", LastName: "Lorca", Birthdate: "1999-03-17", Number: "7574"},
+		{Document: "11111111"},
+	}
+	if !reflect.DeepEqual(winners, want) {
+		t.Errorf("findWinners() = %+v, want %+v", winners, want)
+	}
+}
+
+func TestFindWinnersJoinsEachReportedBetOnce(t *testing.T) {
+	path := writeBetsFile(t,
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Santiago Lionel,Lorca,30904465,1999-03-17,1234",
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+	)
+
+	winners, err := findWinners(path, "1", []string{"30904465", "30904465", "30904465"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(winners) != 3 {
+		t.Errorf("findWinners() = %+v, want 3 winners", winners)
+	}
+
+	winners, err = findWinners(path, "1", []string{"30904465"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(winners) != 1 {
+		t.Errorf("findWinners() = %+v, want a single winner", winners)
+	}
+}
+
+func TestWriteWinnersIsReadableByEveryone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winners.csv")
+	if err := writeWinners(path, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("export mode = %v, want %v", mode, os.FileMode(0o644))
+	}
+}
+
+func TestWriteWinnersWithoutWinners(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "winners.out")
+	if err := writeWinners(path, "json", nil); err != nil {
+		t.Fatal(err)
+	}
+	var got []Winner
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &got); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("export = %q, want an empty list", data)
+	}
+
+	if err := writeWinners(path, "xml", nil); err == nil {
+		t.Error("writeWinners() with an unknown format did not fail")
+	}
+}
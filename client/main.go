@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/op/go-logging"
@@ -11,6 +18,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/pkg/lottery"
 )
 
 var log = logging.MustGetLogger("log")
@@ -34,8 +42,29 @@ func InitConfig() (*viper.Viper, error) {
 	// Add env variables supported
 	v.BindEnv("id")
 	v.BindEnv("server", "address")
+	v.BindEnv("server", "policy")
+	v.BindEnv("server", "resolveTTL")
+	v.BindEnv("server", "tlsCA")
 	v.BindEnv("loop", "period")
-	v.BindEnv("loop", "amount")
+	v.BindEnv("batch", "maxAmount")
+	v.BindEnv("batch", "window")
+	v.BindEnv("batch", "maxRetries")
+	v.BindEnv("breaker", "failures")
+	v.BindEnv("breaker", "cooldown")
+	v.BindEnv("bets", "file")
+	v.BindEnv("rate", "bets")
+	v.BindEnv("rate", "betsBurst")
+	v.BindEnv("rate", "bytes")
+	v.BindEnv("rate", "bytesBurst")
+	v.BindEnv("metrics", "address")
+	v.BindEnv("winners", "output")
+	v.BindEnv("winners", "format")
+	v.BindEnv("capture", "dir")
+	v.BindEnv("draw", "timeout")
+	v.BindEnv("draw", "round")
+	v.BindEnv("progress", "period")
+	v.BindEnv("health", "timeout")
+	v.BindEnv("health", "wait")
 	v.BindEnv("log", "level")
 
 	// Try to read configuration from config file. If config file
@@ -53,6 +82,54 @@ func InitConfig() (*viper.Viper, error) {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
 
+	// Optional time.Duration variables are only parsed when they are defined
+	optionalDurations := map[string]string{
+		"progress.period":   "CLI_PROGRESS_PERIOD",
+		"health.timeout":    "CLI_HEALTH_TIMEOUT",
+		"health.wait":       "CLI_HEALTH_WAIT",
+		"breaker.cooldown":  "CLI_BREAKER_COOLDOWN",
+		"server.resolveTTL": "CLI_SERVER_RESOLVETTL",
+	}
+	for key, env := range optionalDurations {
+		if value := v.GetString(key); value != "" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return nil, errors.Wrapf(err, "Could not parse %s env var as time.Duration.", env)
+			}
+		}
+	}
+
+	if len(common.ParseEndpoints(v.GetString("server.address"))) == 0 {
+		return nil, errors.Errorf("CLI_SERVER_ADDRESS must hold at least one address.")
+	}
+	switch common.EndpointPolicy(v.GetString("server.policy")) {
+	case "", common.Failover, common.RoundRobin, common.Random:
+	default:
+		return nil, errors.Errorf("CLI_SERVER_POLICY must be one of failover, round_robin or random.")
+	}
+
+	if round := v.GetString("draw.round"); round != "" {
+		if _, err := strconv.ParseUint(round, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_DRAW_ROUND env var as a round ID.")
+		}
+	}
+
+	if v.GetInt("batch.maxAmount") <= 0 {
+		return nil, errors.Errorf("CLI_BATCH_MAXAMOUNT must be a positive integer.")
+	}
+
+	// Rate limits are disabled when zero
+	rateLimits := map[string]string{
+		"rate.bets":       "CLI_RATE_BETS",
+		"rate.betsBurst":  "CLI_RATE_BETSBURST",
+		"rate.bytes":      "CLI_RATE_BYTES",
+		"rate.bytesBurst": "CLI_RATE_BYTESBURST",
+	}
+	for key, env := range rateLimits {
+		if v.GetFloat64(key) < 0 {
+			return nil, errors.Errorf("%s cannot be negative.", env)
+		}
+	}
+
 	return v, nil
 }
 
@@ -81,35 +158,302 @@ func InitLogger(logLevel string) error {
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s",
+	log.Infof("action: config | result: success | client_id: %s | server_address: %s | server_policy: %s | server_resolve_ttl: %v | server_tls_ca: %s | loop_period: %v | batch_max_amount: %v | batch_window: %v | batch_max_retries: %v | breaker_failures: %v | breaker_cooldown: %v | bets_file: %s | rate_bets: %v | rate_bets_burst: %v | rate_bytes: %v | rate_bytes_burst: %v | winners_output: %s | winners_format: %s | capture_dir: %s | draw_timeout: %v | draw_round: %v | metrics_address: %s | progress_period: %v | health_timeout: %v | health_wait: %v | log_level: %s",
 		v.GetString("id"),
 		v.GetString("server.address"),
-		v.GetInt("loop.amount"),
+		v.GetString("server.policy"),
+		v.GetDuration("server.resolveTTL"),
+		v.GetString("server.tlsCA"),
 		v.GetDuration("loop.period"),
+		v.GetInt("batch.maxAmount"),
+		v.GetInt("batch.window"),
+		v.GetInt("batch.maxRetries"),
+		v.GetInt("breaker.failures"),
+		v.GetDuration("breaker.cooldown"),
+		v.GetString("bets.file"),
+		v.GetFloat64("rate.bets"),
+		v.GetInt("rate.betsBurst"),
+		v.GetFloat64("rate.bytes"),
+		v.GetInt("rate.bytesBurst"),
+		v.GetString("winners.output"),
+		v.GetString("winners.format"),
+		v.GetString("capture.dir"),
+		v.GetDuration("draw.timeout"),
+		v.GetUint64("draw.round"),
+		v.GetString("metrics.address"),
+		v.GetDuration("progress.period"),
+		v.GetDuration("health.timeout"),
+		v.GetDuration("health.wait"),
 		v.GetString("log.level"),
 	)
 }
 
+// TLSConfig Returns the configuration of tls:// endpoints. When a CA file
+// is configured only the certificates it holds are trusted, otherwise nil
+// is returned so the system roots are used
+func TLSConfig(v *viper.Viper) (*tls.Config, error) {
+	caFile := v.GetString("server.tlsCA")
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+// RunPing Performs a single health request against every configured
+// server endpoint. Returns the exit code of the program: 0 if some endpoint
+// answered in time and 1 otherwise
+func RunPing(v *viper.Viper) int {
+	tlsConfig, err := TLSConfig(v)
+	if err != nil {
+		log.Errorf("action: ping | result: fail | error: %v", err)
+		return 1
+	}
+
+	code := 1
+	for _, address := range common.ParseEndpoints(v.GetString("server.address")) {
+		rtt, err := common.Ping(context.Background(), address, v.GetDuration("health.timeout"), tlsConfig)
+		if err != nil {
+			log.Errorf("action: ping | result: fail | server_address: %s | error: %v", address, err)
+			continue
+		}
+		log.Infof("action: ping | result: success | server_address: %s | rtt: %v", address, rtt)
+		code = 0
+	}
+	return code
+}
+
+// RunValidate Validates an agency file without contacting the server and
+// prints the report to stdout. It does not read the configuration so the
+// report is the only output of the program. Returns the exit code of the
+// program: 0 if the file is valid, even with warnings, 1 if it has errors
+// and 2 on usage errors
+func RunValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	format := flags.String("format", "text", "report format: text or json")
+	agency := flags.String("agency", os.Getenv("CLI_ID"), "agency the file must belong to; taken from the file name when empty")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s validate [flags] <agency-file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	report, err := common.ValidateBetsFile(flags.Arg(0), *agency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: validate | result: fail | file: %s | error: %v\n", flags.Arg(0), err)
+		return 1
+	}
+
+	if *format == "json" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil || !report.Valid() {
+		return 1
+	}
+	return 0
+}
+
+// RunReplay Resends the requests of a capture file to the server and
+// prints how its responses differ from the recorded ones. Returns the exit
+// code of the program: 0 if every response matched, 1 if some differed or
+// the replay failed and 2 on usage errors
+func RunReplay(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	address := flags.String("server", common.ParseEndpoints(v.GetString("server.address"))[0], "address of the server the capture is replayed against")
+	timeout := flags.Duration("timeout", common.DefaultReplayTimeout, "time the server has to answer each request")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] <capture-file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var report common.ReplayReport
+	tlsConfig, err := TLSConfig(v)
+	if err == nil {
+		report, err = common.Replay(context.Background(), *address, flags.Arg(0), *timeout, tlsConfig)
+	}
+	if err != nil {
+		log.Errorf("action: replay | result: fail | file: %s | server_address: %s | error: %v",
+			flags.Arg(0),
+			*address,
+			err,
+		)
+		return 1
+	}
+	if err := report.WriteText(os.Stdout); err != nil || !report.Matches() {
+		return 1
+	}
+	return 0
+}
+
+// RunWinners Asks for the winners of the agency in the draw of a round,
+// which may be a past one, without sending any bet. The documents are
+// printed to stdout, one per line. Returns the exit code of the program:
+// 0 if the winners were received, 1 if they were not and 2 on usage errors
+func RunWinners(ctx context.Context, v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("winners", flag.ContinueOnError)
+	round := flags.Uint64("round", v.GetUint64("draw.round"), "round whose winners are asked for")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s winners [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	tlsConfig, err := TLSConfig(v)
+	if err != nil {
+		log.Errorf("action: consulta_ganadores | result: fail | round: %v | error: %v", *round, err)
+		return 1
+	}
+	client := common.NewClient(NewClientConfig(v, tlsConfig))
+	defer client.Close()
+
+	// Failures were already logged by the client
+	winners, err := client.QueryRoundWinners(ctx, *round)
+	if err != nil {
+		return 1
+	}
+	for _, document := range winners {
+		fmt.Println(document)
+	}
+	return 0
+}
+
+// NewClientConfig Builds the configuration of the client from the parsed
+// configuration parameters
+func NewClientConfig(v *viper.Viper, tlsConfig *tls.Config) common.ClientConfig {
+	return common.ClientConfig{
+		ServerAddresses: common.ParseEndpoints(v.GetString("server.address")),
+		EndpointPolicy:  common.EndpointPolicy(v.GetString("server.policy")),
+		ResolveTTL:      v.GetDuration("server.resolveTTL"),
+		TLSConfig:       tlsConfig,
+		ID:              v.GetString("id"),
+		LoopPeriod:      v.GetDuration("loop.period"),
+		BatchMaxAmount:  v.GetInt("batch.maxAmount"),
+		BatchWindow:     v.GetInt("batch.window"),
+		BatchMaxRetries: v.GetInt("batch.maxRetries"),
+		BreakerFailures: v.GetInt("breaker.failures"),
+		BreakerCoolDown: v.GetDuration("breaker.cooldown"),
+		BetsFile:        v.GetString("bets.file"),
+		BetsPerSecond:   v.GetFloat64("rate.bets"),
+		BetsBurst:       v.GetInt("rate.betsBurst"),
+		BytesPerSecond:  v.GetFloat64("rate.bytes"),
+		BytesBurst:      v.GetInt("rate.bytesBurst"),
+		WinnersOutput:   v.GetString("winners.output"),
+		WinnersFormat:   v.GetString("winners.format"),
+		CaptureDir:      v.GetString("capture.dir"),
+		DrawTimeout:     v.GetDuration("draw.timeout"),
+		Round:           v.GetUint64("draw.round"),
+		MetricsAddress:  v.GetString("metrics.address"),
+		ProgressPeriod:  v.GetDuration("progress.period"),
+		PingTimeout:     v.GetDuration("health.timeout"),
+		WaitTimeout:     v.GetDuration("health.wait"),
+	}
+}
+
+// RunAgency Uploads the bets file of the agency, notifies the server once
+// it was sent and waits for the winners of the agency
+func RunAgency(ctx context.Context, config common.ClientConfig) error {
+	client, err := lottery.DialConfig(ctx, config, lottery.WithLogger(log))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Rejected bets were already logged and do not stop the agency
+	err = client.SendFile(ctx, config.BetsFile)
+	if _, rejected := err.(*lottery.RejectedError); err != nil && !rejected {
+		return err
+	}
+	if err := client.NotifyDone(ctx); err != nil {
+		return err
+	}
+	if _, err := client.QueryWinners(ctx); err != nil {
+		return err
+	}
+
+	log.Infof("action: loop_finished | result: success | client_id: %v", config.ID)
+	return nil
+}
+
 func main() {
+	// The validate command works offline and does not need any configuration
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(RunValidate(os.Args[2:]))
+	}
+
 	v, err := InitConfig()
 	if err != nil {
 		log.Criticalf("%s", err)
+		os.Exit(1)
 	}
 
 	if err := InitLogger(v.GetString("log.level")); err != nil {
 		log.Criticalf("%s", err)
 	}
 
+	// The ping command only checks whether the server is able to attend
+	// requests. Its exit code is meant to be used by health checks
+	if len(os.Args) > 1 && os.Args[1] == "ping" {
+		os.Exit(RunPing(v))
+	}
+
+	// The replay command resends a recorded session to the server
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(RunReplay(v, os.Args[2:]))
+	}
+
+	// Stop the client gracefully when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	// The winners command asks for the winners of a round, such as a past
+	// one, without taking part in it
+	if len(os.Args) > 1 && os.Args[1] == "winners" {
+		code := RunWinners(ctx, v, os.Args[2:])
+		stop()
+		os.Exit(code)
+	}
+
+	// The round the bets are placed in may also be picked by a flag
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	round := flags.Uint64("round", v.GetUint64("draw.round"), "round the bets are placed in and the winners are asked for")
+	flags.Parse(os.Args[1:])
+	v.Set("draw.round", *round)
+
 	// Print program config with debugging purposes
 	PrintConfig(v)
 
-	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopAmount:    v.GetInt("loop.amount"),
-		LoopPeriod:    v.GetDuration("loop.period"),
+	tlsConfig, err := TLSConfig(v)
+	if err != nil {
+		log.Criticalf("action: config | result: fail | error: %v", err)
+		os.Exit(1)
 	}
+	clientConfig := NewClientConfig(v, tlsConfig)
 
-	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	if err := RunAgency(ctx, clientConfig); err != nil {
+		if ctx.Err() != nil {
+			log.Infof("action: shutdown | result: success | client_id: %v", clientConfig.ID)
+			return
+		}
+		stop()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/faultproxy"
+)
+
+var log = logging.MustGetLogger("log")
+
+// InitLogger Configures go-logging with the same format used by the client
+func InitLogger() {
+	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
+	format := logging.MustStringFormatter(
+		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+	)
+	logging.SetBackend(logging.NewBackendFormatter(baseBackend, format))
+}
+
+// Sits between the clients and the server injecting the faults described by
+// a scenario file until it receives SIGINT or SIGTERM. Without a scenario
+// the traffic is forwarded untouched
+func main() {
+	listen := flag.String("listen", ":12346", "address the proxy listens on")
+	target := flag.String("target", "server:12345", "address of the server")
+	scenarioFile := flag.String("scenario", "", "JSON file with the faults to inject")
+	flag.Parse()
+
+	InitLogger()
+
+	var scenario faultproxy.Scenario
+	if *scenarioFile != "" {
+		var err error
+		if scenario, err = faultproxy.LoadScenario(*scenarioFile); err != nil {
+			log.Criticalf("action: load_scenario | result: fail | file: %s | error: %v", *scenarioFile, err)
+			os.Exit(1)
+		}
+	}
+
+	proxy, err := faultproxy.Listen(*listen, *target, scenario)
+	if err != nil {
+		log.Criticalf("action: proxy | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("action: proxy | result: in_progress | address: %s | target: %s | faults: %v",
+		proxy.Addr,
+		*target,
+		len(scenario.Faults),
+	)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	proxy.Close()
+	log.Infof("action: proxy | result: success | connections: %v", proxy.Accepted())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/synthetic"
+)
+
+var log = logging.MustGetLogger("log")
+
+// InitLogger Configures go-logging with the same format used by the client
+func InitLogger() {
+	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
+	format := logging.MustStringFormatter(
+		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+	)
+	logging.SetBackend(logging.NewBackendFormatter(baseBackend, format))
+}
+
+// Generates the agency-{N}.csv files ingested by the client, filled with
+// synthetic bets
+func main() {
+	agencies := flag.Int("agencies", 5, "amount of agency files to generate")
+	rows := flag.Int("rows", 1000, "rows of every agency file")
+	seed := flag.Int64("seed", 1, "seed of the first agency; agency N uses seed+N-1")
+	winners := flag.Float64("winners", synthetic.UniformWinners, "fraction of the bets placed on the winner number; negative picks numbers uniformly")
+	winnerNumber := flag.Int("winner-number", synthetic.LotteryWinnerNumber, "number that wins the draw")
+	malformed := flag.Float64("malformed", 0, "fraction of malformed rows, for negative testing")
+	output := flag.String("output", ".data", "directory where the files are written")
+	flag.Parse()
+
+	InitLogger()
+
+	if *winners > 1 || *malformed < 0 || *malformed > 1 {
+		log.Criticalf("action: generate | result: fail | error: ratios must be at most 1 and malformed at least 0")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*output, 0o755); err != nil {
+		log.Criticalf("action: generate | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+
+	for agency := 1; agency <= *agencies; agency++ {
+		generator := synthetic.NewGenerator(*seed + int64(agency-1))
+		generator.WinnerRatio = *winners
+		generator.WinnerNumber = *winnerNumber
+		generator.MalformedRatio = *malformed
+
+		path := filepath.Join(*output, fmt.Sprintf("agency-%d.csv", agency))
+		if err := generator.WriteFile(path, *rows); err != nil {
+			log.Criticalf("action: generate | result: fail | file: %s | error: %v", path, err)
+			os.Exit(1)
+		}
+		log.Infof("action: generate | result: success | file: %s | rows: %v", path, *rows)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+// frame Payload read from the input. Frames read from a capture also carry
+// the metadata of their record
+type frame struct {
+	payload  []byte
+	captured bool
+	record   capture.Record
+}
+
+// Summary Totals of an inspection
+type Summary struct {
+	Frames    int
+	Malformed int
+}
+
+// frameReader Returns the frames of r one at a time. Captures written by
+// the client are detected by their header; any other input is read as the
+// raw stream of frames sent through a connection
+func frameReader(r io.Reader) (func() (frame, error), error) {
+	buffered := bufio.NewReader(r)
+	prefix, _ := buffered.Peek(len(capture.Magic))
+	if !bytes.Equal(prefix, []byte(capture.Magic)) {
+		return func() (frame, error) {
+			payload, err := framing.ReadFrame(buffered)
+			return frame{payload: payload}, err
+		}, nil
+	}
+
+	records, err := capture.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return func() (frame, error) {
+		record, err := records.Next()
+		return frame{payload: record.Payload, captured: true, record: record}, err
+	}, nil
+}
+
+// Inspect Writes a description of every frame of r to w. Messages that
+// cannot be decoded are described and counted as malformed, while framing
+// errors stop the inspection since the frame boundaries are lost
+func Inspect(r io.Reader, w io.Writer, showBets bool) (Summary, error) {
+	var summary Summary
+	next, err := frameReader(r)
+	if err != nil {
+		return summary, err
+	}
+
+	for {
+		f, err := next()
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+		summary.Frames++
+
+		msg, decodeErr := codec.Decode(f.payload)
+		if decodeErr != nil {
+			summary.Malformed++
+		}
+		if _, err := fmt.Fprintf(w, "#%d %s\n", summary.Frames, describeFrame(f, msg, decodeErr, showBets)); err != nil {
+			return summary, err
+		}
+	}
+}
+
+// describeFrame Header of the frame followed by its decoded message. Bets
+// of batches go on their own indented lines
+func describeFrame(f frame, msg codec.Message, decodeErr error, showBets bool) string {
+	var b strings.Builder
+	if f.captured {
+		fmt.Fprintf(&b, "%s conn=%d %s ",
+			f.record.Time.UTC().Format(time.RFC3339Nano),
+			f.record.Connection,
+			f.record.Direction,
+		)
+	}
+	fmt.Fprintf(&b, "len=%d crc32=%08x ", len(f.payload), crc32.ChecksumIEEE(f.payload))
+
+	if decodeErr != nil {
+		fmt.Fprintf(&b, "malformed: %v: %q", decodeErr, f.payload)
+		return b.String()
+	}
+
+	b.WriteString(msg.Type())
+	switch m := msg.(type) {
+	case codec.BetMessage:
+		b.WriteString(" " + describeBet(m))
+	case codec.BetBatchMessage:
+		fmt.Fprintf(&b, " id=%d bets=%d", m.ID, len(m.Bets))
+		writeRound(&b, m.Round)
+		if showBets {
+			for _, bet := range m.Bets {
+				b.WriteString("\n    " + describeBet(bet))
+			}
+		}
+	case codec.AckMessage:
+		fmt.Fprintf(&b, " batch_id=%d status=%s(%d)", m.BatchID, m.Status, int(m.Status))
+	case codec.EndOfBetsMessage:
+		fmt.Fprintf(&b, " agency=%s", m.Agency)
+		writeRound(&b, m.Round)
+	case codec.WinnersRequestMessage:
+		fmt.Fprintf(&b, " agency=%s", m.Agency)
+		writeRound(&b, m.Round)
+	case codec.RegisterMessage:
+		fmt.Fprintf(&b, " agency=%s", m.Agency)
+		writeRound(&b, m.Round)
+	case codec.RegisteredMessage:
+		fmt.Fprintf(&b, " status=%s(%d)", m.Status, int(m.Status))
+		if m.Commitment != "" {
+			fmt.Fprintf(&b, " commitment=%s", m.Commitment)
+		}
+	case codec.RevealRequestMessage:
+		writeRound(&b, m.Round)
+	case codec.RevealMessage:
+		fmt.Fprintf(&b, " number=%s seed=%s", m.Number, m.Seed)
+	case codec.WinnersNotificationMessage:
+		fmt.Fprintf(&b, " winners=%d documents=[%s]", len(m.Documents), strings.Join(m.Documents, " "))
+	}
+	return b.String()
+}
+
+// writeRound Describes the round of the message unless it is the default one
+func writeRound(b *strings.Builder, round uint64) {
+	if round != codec.DefaultRound {
+		fmt.Fprintf(b, " round=%d", round)
+	}
+}
+
+func describeBet(bet codec.BetMessage) string {
+	return fmt.Sprintf("agency=%s first_name=%q last_name=%q document=%s birthdate=%s number=%s",
+		bet.Agency,
+		bet.FirstName,
+		bet.LastName,
+		bet.Document,
+		bet.Birthdate,
+		bet.Number,
+	)
+}
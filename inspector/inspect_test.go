@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/capture"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/framing"
+)
+
+func encode(t *testing.T, msg codec.Message) []byte {
+	t.Helper()
+	payload, err := codec.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestInspectRawStream(t *testing.T) {
+	batch := codec.BetBatchMessage{ID: 7, Bets: []codec.BetMessage{{
+		Agency:    "1",
+		FirstName: "Nicolás",
+		LastName:  "Peña",
+		Document:  "27726965",
+		Birthdate: "1994-03-16",
+		Number:    "7574",
+	}}}
+
+	var stream bytes.Buffer
+	framing.WriteFrame(&stream, encode(t, batch))
+	framing.WriteFrame(&stream, []byte("NotAMessage|1"))
+	framing.WriteFrame(&stream, encode(t, codec.AckMessage{BatchID: 7, Status: codec.AckBusy}))
+
+	var out bytes.Buffer
+	summary, err := Inspect(&stream, &out, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (Summary{Frames: 3, Malformed: 1}) {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"#1 len=",
+		`    agency=1 first_name="Nicolás" last_name="Peña" document=27726965 birthdate=1994-03-16 number=7574`,
+		"#2 len=13 ",
+		"#3 len=",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("output =\n%s", out.String())
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d = %q, want prefix %q", i+1, lines[i], prefix)
+		}
+	}
+	if !strings.HasSuffix(lines[0], "BetBatchMessage id=7 bets=1") {
+		t.Errorf("batch line = %q", lines[0])
+	}
+	if !strings.Contains(lines[2], "malformed: ") {
+		t.Errorf("malformed line = %q", lines[2])
+	}
+	if !strings.HasSuffix(lines[3], "AckMessage batch_id=7 status=busy(2)") {
+		t.Errorf("ack line = %q", lines[3])
+	}
+}
+
+func TestInspectCapture(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := capture.NewWriter(&buf, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Record(capture.Sent, 2, encode(t, codec.WinnersRequestMessage{Agency: "3"}))
+	writer.Record(capture.Received, 2, encode(t, codec.WinnersNotificationMessage{Documents: []string{"30904465", "27726965"}}))
+	writer.Flush()
+
+	var out bytes.Buffer
+	summary, err := Inspect(&buf, &out, false)
+	if err != nil || summary.Frames != 2 {
+		t.Fatalf("Inspect() = %+v, %v", summary, err)
+	}
+	if !strings.Contains(out.String(), " conn=2 sent len=") ||
+		!strings.Contains(out.String(), "WinnersNotificationMessage winners=2 documents=[30904465 27726965]") {
+		t.Errorf("output =\n%s", out.String())
+	}
+}
+
+func TestInspectStopsOnTruncatedFrame(t *testing.T) {
+	var stream bytes.Buffer
+	framing.WriteFrame(&stream, encode(t, codec.PingMessage{}))
+	stream.Write([]byte{0, 0, 0, 9, 'P'})
+
+	summary, err := Inspect(&stream, &bytes.Buffer{}, true)
+	if err == nil || summary.Frames != 1 {
+		t.Errorf("Inspect() = %+v, %v, want an error after 1 frame", summary, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Prints the protocol frames of a capture file written by the client, or of
+// a raw stream of frames such as the payload of a TCP connection exported
+// from tcpdump. Frames are read from stdin when no file is given. Exits with
+// 1 if some frame could not be read or decoded and 2 on usage errors
+func main() {
+	showBets := flag.Bool("bets", true, "print every bet of the batches")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [capture-file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var input io.Reader = os.Stdin
+	if flag.NArg() == 1 {
+		file, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "action: inspect | result: fail | error: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	summary, err := Inspect(input, os.Stdout, *showBets)
+	fmt.Printf("%d frames, %d malformed\n", summary.Frames, summary.Malformed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: inspect | result: fail | frame: %d | error: %v\n", summary.Frames+1, err)
+		os.Exit(1)
+	}
+	if summary.Malformed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/synthetic"
+)
+
+// LoadConfig Configuration of a load generation run
+type LoadConfig struct {
+	ServerAddress string
+	Agencies      int
+	// BetsPerAgency Amount of synthetic bets of every agency. Ignored when
+	// DataDir is set
+	BetsPerAgency int
+	// DataDir Directory holding the agency-{N}.csv files. When empty the
+	// agencies upload synthetic bets
+	DataDir        string
+	Seed           int64
+	BatchMaxAmount int
+	BatchWindow    int
+	LoopPeriod     time.Duration
+	// RateLimit Bets per second uploaded by every agency. Unlimited when
+	// zero
+	RateLimit float64
+}
+
+// Report Aggregated results of every simulated agency
+type Report struct {
+	Agencies       int
+	FailedAgencies int
+	BetsSent       int64
+	BatchesAcked   int64
+	BatchesFailed  int64
+	Retries        int64
+	Elapsed        time.Duration
+	// UploadElapsed Time taken by the agencies to upload their bets, until
+	// the last of them notified the end of its bets
+	UploadElapsed time.Duration
+	// BetLatencies Round-trip times of the batches, sorted
+	BetLatencies []time.Duration
+	// WinnersLatencies Round-trip times of the winners requests, sorted
+	WinnersLatencies []time.Duration
+}
+
+// BetsPerSecond Amount of bets sent per second while uploading them. The
+// wait for the draw is left out
+func (r Report) BetsPerSecond() float64 {
+	if r.UploadElapsed <= 0 {
+		return 0
+	}
+	return float64(r.BetsSent) / r.UploadElapsed.Seconds()
+}
+
+// Percentile Returns the latency below which the given percentage of the
+// sorted latencies fall, using the nearest-rank method
+func Percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(latencies) {
+		rank = len(latencies)
+	}
+	return latencies[rank-1]
+}
+
+// latencyRecorder Collects the round-trip times of every client
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencyRecorder) observe(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples = append(l.samples, rtt)
+}
+
+// sorted Returns the samples collected in increasing order
+func (l *latencyRecorder) sorted() []time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	samples := append([]time.Duration(nil), l.samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples
+}
+
+// agency Client of a simulated agency. Its round-trip times are recorded
+// as bet latencies until it finished uploading and as winners latencies
+// afterwards
+type agency struct {
+	client   *common.Client
+	file     string
+	uploaded int32
+	// uploadElapsed Time from the start of the run until the agency
+	// notified the end of its bets
+	uploadElapsed time.Duration
+}
+
+// run Goes through the same steps as StartClientLoop, recording when the
+// upload finished
+func (a *agency) run(ctx context.Context, start time.Time) error {
+	defer a.client.Close()
+	if err := a.client.Connect(ctx); err != nil {
+		return err
+	}
+
+	// Rejected batches were already logged and do not stop the agency
+	err := a.client.SendBetsFile(ctx, a.file)
+	if _, rejected := err.(*common.RejectedError); err != nil && !rejected {
+		return err
+	}
+	if err := a.client.NotifyDone(ctx); err != nil {
+		return err
+	}
+	a.uploadElapsed = time.Since(start)
+	atomic.StoreInt32(&a.uploaded, 1)
+
+	_, err = a.client.QueryWinners(ctx)
+	return err
+}
+
+// Run Spawns one client per agency, waits for all of them to finish and
+// returns the aggregated results
+func Run(ctx context.Context, config LoadConfig) (Report, error) {
+	if config.Agencies < 1 {
+		return Report{}, errors.Errorf("at least one agency is required, got %d", config.Agencies)
+	}
+
+	files, cleanup, err := agencyFiles(config)
+	if err != nil {
+		return Report{}, err
+	}
+	defer cleanup()
+
+	bets, winners := &latencyRecorder{}, &latencyRecorder{}
+	agencies := make([]*agency, config.Agencies)
+	for i := range agencies {
+		a := &agency{file: files[i]}
+		a.client = common.NewClient(common.ClientConfig{
+			ID:             strconv.Itoa(i + 1),
+			ServerAddress:  config.ServerAddress,
+			LoopPeriod:     config.LoopPeriod,
+			BatchMaxAmount: config.BatchMaxAmount,
+			BatchWindow:    config.BatchWindow,
+			BetsPerSecond:  config.RateLimit,
+			BetsFile:       files[i],
+		})
+		a.client.Metrics().OnRTT(func(rtt time.Duration) {
+			if atomic.LoadInt32(&a.uploaded) == 0 {
+				bets.observe(rtt)
+			} else {
+				winners.observe(rtt)
+			}
+		})
+		agencies[i] = a
+	}
+
+	start := time.Now()
+	errs := make([]error, len(agencies))
+	var wg sync.WaitGroup
+	for i, a := range agencies {
+		wg.Add(1)
+		go func(i int, a *agency) {
+			defer wg.Done()
+			errs[i] = a.run(ctx, start)
+		}(i, a)
+	}
+	wg.Wait()
+
+	report := Report{
+		Agencies:         config.Agencies,
+		Elapsed:          time.Since(start),
+		BetLatencies:     bets.sorted(),
+		WinnersLatencies: winners.sorted(),
+	}
+	for i, a := range agencies {
+		if errs[i] != nil {
+			report.FailedAgencies++
+		}
+		if a.uploadElapsed > report.UploadElapsed {
+			report.UploadElapsed = a.uploadElapsed
+		}
+		snapshot := a.client.Metrics().Snapshot()
+		report.BetsSent += snapshot.BetsSent
+		report.BatchesAcked += snapshot.BatchesAcked
+		report.BatchesFailed += snapshot.BatchesFailed
+		report.Retries += snapshot.Retries
+	}
+	return report, ctx.Err()
+}
+
+// agencyFiles Returns the path of the file of every agency. Synthetic files
+// are written to a temporary directory removed by the returned function
+func agencyFiles(config LoadConfig) ([]string, func(), error) {
+	files := make([]string, config.Agencies)
+	if config.DataDir != "" {
+		for i := range files {
+			files[i] = filepath.Join(config.DataDir, fmt.Sprintf("agency-%d.csv", i+1))
+		}
+		return files, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "loadgen")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	for i := range files {
+		files[i] = filepath.Join(dir, fmt.Sprintf("agency-%d.csv", i+1))
+		generator := synthetic.NewGenerator(config.Seed + int64(i))
+		if err := generator.WriteFile(files[i], config.BetsPerAgency); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+	return files, cleanup, nil
+}
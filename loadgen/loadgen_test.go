@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+func TestRunAgainstFakeServer(t *testing.T) {
+	server, err := lotterytest.NewServer(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	report, err := Run(context.Background(), LoadConfig{
+		ServerAddress:  server.Addr,
+		Agencies:       3,
+		BetsPerAgency:  50,
+		Seed:           7574,
+		BatchMaxAmount: 10,
+		BatchWindow:    2,
+		LoopPeriod:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if report.FailedAgencies != 0 || report.BetsSent != 150 || report.BatchesAcked != 15 {
+		t.Errorf("failed agencies %d, bets sent %d, batches acked %d, want 0, 150, 15",
+			report.FailedAgencies, report.BetsSent, report.BatchesAcked)
+	}
+	if got := len(server.Bets()); got != 150 {
+		t.Errorf("server stored %d bets, want 150", got)
+	}
+	// Every batch, then at least one winners request per agency
+	if len(report.BetLatencies) != 15 {
+		t.Errorf("%d bet latencies recorded, want 15", len(report.BetLatencies))
+	}
+	if len(report.WinnersLatencies) < 3 {
+		t.Errorf("%d winners latencies recorded, want at least 3", len(report.WinnersLatencies))
+	}
+	if p50, p99 := Percentile(report.BetLatencies, 50), Percentile(report.BetLatencies, 99); p50 > p99 {
+		t.Errorf("p50 %v is above p99 %v", p50, p99)
+	}
+	if report.UploadElapsed <= 0 || report.UploadElapsed > report.Elapsed {
+		t.Errorf("upload took %v of the %v run", report.UploadElapsed, report.Elapsed)
+	}
+}
+
+func TestPercentileUsesNearestRank(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 10; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	for p, want := range map[float64]time.Duration{
+		0:   1 * time.Millisecond,
+		10:  1 * time.Millisecond,
+		11:  2 * time.Millisecond,
+		15:  2 * time.Millisecond,
+		50:  5 * time.Millisecond,
+		55:  6 * time.Millisecond,
+		90:  9 * time.Millisecond,
+		99:  10 * time.Millisecond,
+		100: 10 * time.Millisecond,
+	} {
+		if got := Percentile(latencies, p); got != want {
+			t.Errorf("Percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// log Logger of the load generator. It uses its own module so its level is
+// independent of the level of the clients, which log through module "log"
+var log = logging.MustGetLogger("loadgen")
+
+// InitLogger Receives the log level of the clients to be set in go-logging as
+// a string. The load generator always logs at INFO level so the report is
+// printed even when the clients are silenced
+func InitLogger(logLevel string) error {
+	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
+	format := logging.MustStringFormatter(
+		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+	)
+	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
+
+	backendLeveled := logging.AddModuleLevel(backendFormatter)
+	logLevelCode, err := logging.LogLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	backendLeveled.SetLevel(logLevelCode, "")
+	backendLeveled.SetLevel(logging.INFO, "loadgen")
+
+	logging.SetBackend(backendLeveled)
+	return nil
+}
+
+func main() {
+	var config LoadConfig
+	flag.StringVar(&config.ServerAddress, "server", "localhost:12345", "address of the server")
+	flag.IntVar(&config.Agencies, "agencies", 5, "amount of simulated agencies")
+	flag.IntVar(&config.BetsPerAgency, "bets", 1000, "synthetic bets uploaded by every agency")
+	flag.StringVar(&config.DataDir, "data", "", "directory holding agency-{N}.csv files to upload instead of synthetic bets")
+	flag.Int64Var(&config.Seed, "seed", 1, "seed of the synthetic bets")
+	flag.IntVar(&config.BatchMaxAmount, "batch", 100, "maximum amount of bets per batch")
+	flag.IntVar(&config.BatchWindow, "window", 1, "maximum amount of unacknowledged batches per agency")
+	flag.DurationVar(&config.LoopPeriod, "period", 100*time.Millisecond, "time between batches and between winners requests of the same agency")
+	flag.Float64Var(&config.RateLimit, "rate", 0, "bets per second uploaded by every agency; unlimited when 0")
+	logLevel := flag.String("log", "WARNING", "log level of the clients")
+	flag.Parse()
+
+	if err := InitLogger(*logLevel); err != nil {
+		log.Criticalf("%s", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	report, err := Run(ctx, config)
+	if err != nil && ctx.Err() == nil {
+		log.Criticalf("action: loadgen | result: fail | error: %v", err)
+		stop()
+		os.Exit(1)
+	}
+
+	log.Infof("action: loadgen | result: success | agencies: %v | failed_agencies: %v | bets_sent: %v | batches_acked: %v | batches_failed: %v | retries: %v | elapsed: %v | upload_elapsed: %v | bets_per_second: %.1f",
+		report.Agencies,
+		report.FailedAgencies,
+		report.BetsSent,
+		report.BatchesAcked,
+		report.BatchesFailed,
+		report.Retries,
+		report.Elapsed.Round(time.Millisecond),
+		report.UploadElapsed.Round(time.Millisecond),
+		report.BetsPerSecond(),
+	)
+	logLatencies("bets", report.BetLatencies)
+	logLatencies("winners", report.WinnersLatencies)
+	if report.FailedAgencies > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// logLatencies Logs the distribution of the latencies of a type of request
+func logLatencies(request string, latencies []time.Duration) {
+	log.Infof("action: loadgen_latency | result: success | request: %v | count: %v | p50: %v | p90: %v | p99: %v | max: %v",
+		request,
+		len(latencies),
+		Percentile(latencies, 50),
+		Percentile(latencies, 90),
+		Percentile(latencies, 99),
+		Percentile(latencies, 100),
+	)
+}
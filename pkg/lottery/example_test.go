@@ -0,0 +1,91 @@
+package lottery_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/pkg/lottery"
+)
+
+func Example() {
+	// A fake server that draws once a single agency is done
+	server, err := lotterytest.NewServer(1)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := lottery.Dial(ctx, server.Addr, "1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer client.Close()
+
+	bets := []lottery.Bet{
+		{FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"},
+		{FirstName: "Maria Antonella", LastName: "Leiva", Document: "24260718", Birthdate: "1987-08-01", Number: "8676"},
+	}
+	if err := client.SendBets(ctx, bets); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := client.NotifyDone(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	winners, err := client.QueryWinners(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("winners:", winners)
+	// Output: winners: [30904465]
+}
+
+func ExampleClient_SendBets() {
+	ctx := context.Background()
+	client, err := lottery.Dial(ctx, "server:12345", "1")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer client.Close()
+
+	err = client.SendBets(ctx, []lottery.Bet{
+		{FirstName: "Nicolás", LastName: "Peña", Document: "27726965", Birthdate: "1994-03-16", Number: "7574"},
+	})
+	if rejected, ok := err.(*lottery.RejectedError); ok {
+		fmt.Printf("%d bets were not stored\n", rejected.Bets)
+	} else if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func ExampleDialConfig() {
+	ctx := context.Background()
+	client, err := lottery.DialConfig(ctx, lottery.Config{
+		ID:              "1",
+		ServerAddresses: []string{"tls://server-a:12345", "tls://server-b:12345"},
+		EndpointPolicy:  lottery.RoundRobin,
+		BatchMaxAmount:  200,
+		BatchWindow:     4,
+		LoopPeriod:      time.Second,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer client.Close()
+
+	if err := client.SendFile(ctx, "agency-1.csv"); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package lottery lets Go programs submit the bets of an agency to the
+// lottery server and learn its winners, speaking the same protocol as the
+// agency client.
+//
+// A session follows the steps of the protocol: Dial connects on behalf of
+// an agency, SendBets and SendFile upload bets, NotifyDone tells the server
+// the agency finished and QueryWinners waits for the draw. Batching,
+// retries, backpressure and reconnections are handled by the session. The
+// methods of a Client are not safe for concurrent use.
+package lottery
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/draw"
+)
+
+const (
+	// DefaultBatchSize Maximum amount of bets sent in a single batch by
+	// the sessions opened with Dial
+	DefaultBatchSize = 100
+	// DefaultPollPeriod Time the sessions opened with Dial wait before
+	// asking for the winners again while the draw is pending
+	DefaultPollPeriod = time.Second
+	// DefaultDrawTimeout Time the sessions opened with Dial wait for the
+	// server to announce the draw before polling for the winners
+	DefaultDrawTimeout = 30 * time.Second
+)
+
+// Bet A bet placed in an agency. Bets without agency are sent as placed
+// in the agency of the session
+type Bet = common.Bet
+
+// Config Configuration of a session. ID is the agency and ServerAddress,
+// or ServerAddresses, the server. Fields left empty disable the feature
+// they configure, such as MetricsAddress or CaptureDir
+type Config = common.ClientConfig
+
+// EndpointPolicy Chooses among the ServerAddresses of the configuration
+type EndpointPolicy = common.EndpointPolicy
+
+const (
+	// Failover Uses the first healthy address
+	Failover = common.Failover
+	// RoundRobin Rotates among the healthy addresses
+	RoundRobin = common.RoundRobin
+	// Random Picks a random healthy address
+	Random = common.Random
+)
+
+// Option Replaces a collaborator of the session, such as its logger
+type Option = common.Option
+
+// Logger Receives the log lines of a session. It is satisfied by the
+// *logging.Logger of go-logging
+type Logger = common.Logger
+
+var (
+	// ErrClosed Returned by the methods called after Close
+	ErrClosed = common.ErrClosed
+	// ErrBetsDone Returned when bets are sent, or the server is notified
+	// again, once NotifyDone succeeded
+	ErrBetsDone = common.ErrBetsDone
+	// ErrCircuitOpen Returned while the server keeps failing and the
+	// session stopped connecting to it for a while
+	ErrCircuitOpen = common.ErrCircuitOpen
+	// ErrUnsupportedScheme Returned when the scheme of a server address
+	// is not tcp, tls or unix
+	ErrUnsupportedScheme = common.ErrUnsupportedScheme
+	// ErrNotInRoster Returned when the server does not expect the agency
+	// to take part in the draw
+	ErrNotInRoster = common.ErrNotInRoster
+	// ErrDrawMissed Returned when the draw took place without the agency
+	ErrDrawMissed = common.ErrDrawMissed
+	// ErrUnknownRound Returned when the winners of a round the server
+	// knows nothing about are asked for
+	ErrUnknownRound = common.ErrUnknownRound
+	// ErrCommitmentChanged Returned when the server commits to another
+	// draw, or to none, as the agency registers again
+	ErrCommitmentChanged = common.ErrCommitmentChanged
+	// ErrWinnersMismatch Returned when the winners the server answers are
+	// not the bets it acknowledged holding the winning number it revealed
+	ErrWinnersMismatch = common.ErrWinnersMismatch
+	// ErrCommitmentMismatch Returned when the seed the server revealed is
+	// not the one it committed to
+	ErrCommitmentMismatch = draw.ErrCommitmentMismatch
+	// ErrNumberMismatch Returned when the winning number the server
+	// announced does not derive from the seed it revealed
+	ErrNumberMismatch = draw.ErrNumberMismatch
+)
+
+// RejectedError Returned by SendBets when the server did not store some of
+// the bets. The remaining bets were stored
+type RejectedError = common.RejectedError
+
+// UnexpectedMessageError Returned when the server answers with a message
+// the protocol does not allow at that point
+type UnexpectedMessageError = common.UnexpectedMessageError
+
+// WithLogger Makes the session log through logger. Sessions do not log
+// otherwise
+func WithLogger(logger Logger) Option {
+	return common.WithLogger(logger)
+}
+
+// WithMetrics Makes the session update metrics, which can be exposed by
+// the program
+func WithMetrics(metrics *common.Metrics) Option {
+	return common.WithMetrics(metrics)
+}
+
+// WithTransport Makes the session connect to the addresses with the given
+// scheme through transport
+func WithTransport(scheme string, transport common.Transport) Option {
+	return common.WithTransport(scheme, transport)
+}
+
+// Client Session of an agency with the lottery server
+type Client struct {
+	client *common.Client
+	agency string
+}
+
+// Dial Connects to the server at address on behalf of agency using the
+// default batch size and poll period
+func Dial(ctx context.Context, address string, agency string, options ...Option) (*Client, error) {
+	return DialConfig(ctx, Config{
+		ID:             agency,
+		ServerAddress:  address,
+		BatchMaxAmount: DefaultBatchSize,
+		LoopPeriod:     DefaultPollPeriod,
+		DrawTimeout:    DefaultDrawTimeout,
+	}, options...)
+}
+
+// DialConfig Connects to the server described by config. ctx only bounds
+// the connection attempts
+func DialConfig(ctx context.Context, config Config, options ...Option) (*Client, error) {
+	options = append([]Option{common.WithLogger(discardLogger{})}, options...)
+	client := common.NewClient(config, options...)
+	if err := client.Connect(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Client{client: client, agency: config.ID}, nil
+}
+
+// SendBets Uploads the bets and returns once the server answered all of
+// them. A *RejectedError is returned when some of them were not stored
+func (c *Client) SendBets(ctx context.Context, bets []Bet) error {
+	return c.client.SendBets(ctx, &betSlice{bets: bets, agency: c.agency})
+}
+
+// SendFile Uploads the bets of an agency file, a CSV file with the first
+// name, last name, document, birthdate and number of every bet. Rows that
+// are not valid bets are skipped
+func (c *Client) SendFile(ctx context.Context, path string) error {
+	return c.client.SendBetsFile(ctx, path)
+}
+
+// NotifyDone Tells the server the agency will not send more bets
+func (c *Client) NotifyDone(ctx context.Context) error {
+	return c.client.NotifyDone(ctx)
+}
+
+// QueryWinners Waits for the draw and returns the documents of the winners
+// of the agency. The draw takes place once every agency notified it is
+// done. The server announces it to sessions with a draw timeout, the rest
+// poll for the winners. A winning number the server committed to is
+// verified before returning
+func (c *Client) QueryWinners(ctx context.Context) ([]string, error) {
+	return c.client.QueryWinners(ctx)
+}
+
+// QueryRoundWinners Returns the documents of the winners of the agency in
+// the draw of the given round, such as a past one. Sessions take part in
+// the round of their Config
+func (c *Client) QueryRoundWinners(ctx context.Context, round uint64) ([]string, error) {
+	return c.client.QueryRoundWinners(ctx, round)
+}
+
+// Close Ends the session
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// betSlice Bet source reading the bets given to SendBets
+type betSlice struct {
+	bets   []Bet
+	agency string
+}
+
+func (s *betSlice) Read() (Bet, error) {
+	if len(s.bets) == 0 {
+		return Bet{}, io.EOF
+	}
+	bet := s.bets[0]
+	s.bets = s.bets[1:]
+	if bet.Agency == "" {
+		bet.Agency = s.agency
+	}
+	return bet, nil
+}
+
+// discardLogger Logger of the sessions that were not given one
+type discardLogger struct{}
+
+func (discardLogger) Debugf(format string, args ...interface{})    {}
+func (discardLogger) Infof(format string, args ...interface{})     {}
+func (discardLogger) Warningf(format string, args ...interface{})  {}
+func (discardLogger) Errorf(format string, args ...interface{})    {}
+func (discardLogger) Criticalf(format string, args ...interface{}) {}
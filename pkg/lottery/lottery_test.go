@@ -0,0 +1,108 @@
+package lottery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/codec"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common/lotterytest"
+)
+
+func dialTestServer(t *testing.T, server *lotterytest.Server) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := DialConfig(ctx, Config{
+		ID:             "1",
+		ServerAddress:  server.Addr,
+		BatchMaxAmount: 1,
+		LoopPeriod:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func startTestServer(t *testing.T) *lotterytest.Server {
+	t.Helper()
+	server, err := lotterytest.NewServer(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+var testBets = []Bet{
+	{FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"},
+	{FirstName: "Maria Antonella", LastName: "Leiva", Document: "24260718", Birthdate: "1987-08-01", Number: "8676"},
+}
+
+func TestSendBetsAcrossCalls(t *testing.T) {
+	server := startTestServer(t)
+	client := dialTestServer(t, server)
+	ctx := context.Background()
+
+	for _, bet := range testBets {
+		if err := client.SendBets(ctx, []Bet{bet}); err != nil {
+			t.Fatalf("SendBets() = %v", err)
+		}
+	}
+	bets := server.Bets()
+	if len(bets) != 2 || bets[1].Agency != "1" {
+		t.Fatalf("server stored %+v", bets)
+	}
+}
+
+func TestSendBetsReportsRejectedBets(t *testing.T) {
+	server := startTestServer(t)
+	server.AnswerBatches(func(batch codec.BetBatchMessage) codec.AckStatus {
+		if batch.Bets[0].Number == "8676" {
+			return codec.AckRejected
+		}
+		return codec.AckOK
+	})
+	client := dialTestServer(t, server)
+
+	err := client.SendBets(context.Background(), testBets)
+	rejected, ok := err.(*RejectedError)
+	if !ok || rejected.Bets != 1 {
+		t.Fatalf("SendBets() = %v, want 1 rejected bet", err)
+	}
+	if got := len(server.Bets()); got != 1 {
+		t.Errorf("server stored %d bets, want 1", got)
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	server := startTestServer(t)
+	client := dialTestServer(t, server)
+	ctx := context.Background()
+
+	if err := client.NotifyDone(ctx); err != nil {
+		t.Fatalf("NotifyDone() = %v", err)
+	}
+	if err := client.SendBets(ctx, testBets); err != ErrBetsDone {
+		t.Errorf("SendBets() after NotifyDone = %v, want %v", err, ErrBetsDone)
+	}
+	if err := client.NotifyDone(ctx); err != ErrBetsDone {
+		t.Errorf("second NotifyDone() = %v, want %v", err, ErrBetsDone)
+	}
+
+	client.Close()
+	if _, err := client.QueryWinners(ctx); err != ErrClosed {
+		t.Errorf("QueryWinners() after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestDialRejectsUnknownScheme(t *testing.T) {
+	_, err := Dial(context.Background(), "quic://server:12345", "1")
+	if err == nil || errors.Cause(err) != ErrUnsupportedScheme {
+		t.Fatalf("Dial() = %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
@@ -0,0 +1,274 @@
+import hashlib
+import logging
+import secrets
+import threading
+
+from common.protocol import ACK_BUSY, ACK_OK, ACK_REJECTED, DEFAULT_ROUND, REGISTER_CLOSED, REGISTER_OK, REGISTER_UNKNOWN
+from common.utils import Bet, has_won, store_bets
+
+
+class DrawMissed(Exception):
+    """ Raised when the draw took place without the agency. """
+
+
+class UnknownRound(Exception):
+    """ Raised when asking about a round no agency took part in. """
+
+
+class DrawNotCommitted(Exception):
+    """ Raised when asking for the seed of a draw that does not commit to one. """
+
+
+""" Random bytes of the seed of a committed draw. """
+SEED_SIZE = 32
+""" Winning numbers go from 0 up to NUMBER_RANGE, exclusive, like the numbers of the bets. """
+NUMBER_RANGE = 10000
+
+
+def commitment(seed: str) -> str:
+    """ Hash of the seed sent to the agencies before the bets close, hex encoded. """
+    return hashlib.sha256(seed.encode()).hexdigest()
+
+
+def winning_number(seed: str) -> int:
+    """ Number derived from the seed. The hash is tagged so the number cannot be read from the commitment. """
+    digest = hashlib.sha256(('winning-number:' + seed).encode()).digest()
+    return int.from_bytes(digest[:8], 'big') % NUMBER_RANGE
+
+
+class _Round:
+    """ Batches, agencies and draw of a single round. """
+
+    def __init__(self, seed: str = None):
+        self.stored = set()
+        self.finished = set()
+        self.subscribers = set()
+        # Documents of the winning bets of every agency, collected as they
+        # are stored since the storage does not tell rounds apart
+        self.winners = {}
+        # Agencies whose bets took part in the draw, None until it takes place
+        self.participants = None
+        self.timer = None
+        # Seed the winning number is derived from when the draw commits to
+        # one, the fixed winning number otherwise
+        self.seed = seed
+
+    def has_won(self, bet: Bet) -> bool:
+        if self.seed is None:
+            return has_won(bet)
+        return bet.number == winning_number(self.seed)
+
+
+class Lottery:
+    """
+    Keeps the state shared by the connections of every agency: the batches
+    already stored, the agencies that finished sending their bets, the
+    subscribers to be told about the draw and the winners once it took
+    place. Every round has its own draw. Thread-safe
+
+    The draw of a round waits for every agency of the roster or, without a
+    roster, for total_agencies agencies. When a deadline is given, the draw
+    also takes place that many seconds after the first registration for the
+    round, with the agencies that finished by then. notify is called with
+    the subscribers to tell that the draw took place. When max_pending is
+    given, batches beyond that many waiting to be stored are answered busy
+
+    With commit_reveal, the winning number of every round is derived from a
+    random seed picked when the round starts. Agencies are sent its
+    commitment as they register and may ask for the seed after the draw
+    """
+
+    def __init__(self, total_agencies: int, roster=None, deadline: float = 0, notify=None, max_pending: int = 0,
+                 commit_reveal: bool = False):
+        self._lock = threading.Lock()
+        self._total_agencies = total_agencies
+        self._roster = {int(agency) for agency in roster} if roster else None
+        self._deadline = deadline
+        self._notify = notify or (lambda subscribers: None)
+        self._closed = False
+        self._pending = threading.BoundedSemaphore(max_pending) if max_pending > 0 else None
+        self._commit_reveal = commit_reveal
+        # Rounds start once an agency registers, sends bets or finishes for them
+        self._rounds = {}
+
+    def register(self, agency: str, round_id: int = DEFAULT_ROUND) -> int:
+        """
+        Registers the agency for the round unless it is not expected or the
+        draw already took place without it. The draw deadline starts with
+        the first registration
+        """
+        agency = int(agency)
+        with self._lock:
+            if not self._expected(agency):
+                return REGISTER_UNKNOWN
+            current = self._round(round_id)
+            if current.participants is not None:
+                return REGISTER_OK if agency in current.participants else REGISTER_CLOSED
+            if self._deadline > 0 and current.timer is None and not self._closed:
+                current.timer = threading.Timer(self._deadline, self._deadline_expired, args=(round_id,))
+                current.timer.daemon = True
+                current.timer.start()
+            return REGISTER_OK
+
+    def store(self, batch_id: int, bets: list, round_id: int = DEFAULT_ROUND) -> int:
+        """
+        Persists the bets of a batch and returns the status of its ack.
+        Batches sent again after a lost ack are acknowledged without being
+        stored twice. Bets of unexpected agencies, or that arrive once the
+        draw took place, are rejected as they would never take part in it.
+        Batches that find the queue of pending batches full are answered
+        busy for the agency to send them again later
+        """
+        if not bets:
+            return ACK_OK
+        agency = int(bets[0][0])
+        if any(int(bet[0]) != agency for bet in bets):
+            return ACK_REJECTED
+
+        if self._pending is None:
+            return self._store(agency, batch_id, bets, round_id)
+        if not self._pending.acquire(blocking=False):
+            return ACK_BUSY
+        try:
+            return self._store(agency, batch_id, bets, round_id)
+        finally:
+            self._pending.release()
+
+    def _store(self, agency: int, batch_id: int, bets: list, round_id: int) -> int:
+        with self._lock:
+            if not self._expected(agency):
+                return ACK_REJECTED
+            current = self._round(round_id)
+            key = (agency, batch_id)
+            if key in current.stored:
+                return ACK_OK
+            if current.participants is not None:
+                return ACK_REJECTED
+            stored = [Bet(*bet) for bet in bets]
+            store_bets(stored)
+            current.stored.add(key)
+            for bet in stored:
+                if current.has_won(bet):
+                    current.winners.setdefault(agency, []).append(bet.document)
+            return ACK_OK
+
+    def finish(self, agency: str, subscriber, round_id: int = DEFAULT_ROUND) -> None:
+        """
+        Records that the agency sent all of its bets for the round and
+        subscribes it to the draw, which takes place once every expected
+        agency did. Raises DrawMissed when the draw already took place
+        without the agency
+        """
+        agency = int(agency)
+        with self._lock:
+            if not self._expected(agency):
+                return
+            current = self._round(round_id)
+            if current.participants is not None:
+                if agency not in current.participants:
+                    raise DrawMissed()
+                subscribers = [subscriber]
+            else:
+                current.finished.add(agency)
+                current.subscribers.add(subscriber)
+                if not self._all_finished(current):
+                    return
+                subscribers = self._draw(current)
+        self._notify(subscribers)
+
+    def unsubscribe(self, subscriber) -> None:
+        """ Forgets a subscriber whose connection was closed. """
+        with self._lock:
+            for current in self._rounds.values():
+                current.subscribers.discard(subscriber)
+
+    def winners(self, agency: str, round_id: int = DEFAULT_ROUND):
+        """
+        Documents of the winning bets of the agency in the round, None
+        before its draw. Raises DrawMissed when the draw took place without
+        the agency and UnknownRound when nobody took part in the round
+        """
+        agency = int(agency)
+        with self._lock:
+            current = self._rounds.get(round_id)
+            if current is None:
+                raise UnknownRound()
+            if current.participants is None:
+                return None
+            if agency not in current.participants:
+                raise DrawMissed()
+            return current.winners.get(agency, [])
+
+    def commitment(self, round_id: int = DEFAULT_ROUND):
+        """ Commitment to the draw of the round, None unless draws commit to their seed. """
+        with self._lock:
+            current = self._round(round_id)
+            return None if current.seed is None else commitment(current.seed)
+
+    def reveal(self, round_id: int = DEFAULT_ROUND):
+        """
+        Winning number of the round and the seed it was derived from, None
+        before its draw. Raises DrawNotCommitted unless draws commit to
+        their seed and UnknownRound when nobody took part in the round
+        """
+        if not self._commit_reveal:
+            raise DrawNotCommitted()
+        with self._lock:
+            current = self._rounds.get(round_id)
+            if current is None:
+                raise UnknownRound()
+            if current.participants is None:
+                return None
+            return winning_number(current.seed), current.seed
+
+    def close(self) -> None:
+        """ Stops waiting for the draw deadlines. """
+        with self._lock:
+            self._closed = True
+            for current in self._rounds.values():
+                if current.timer is not None:
+                    current.timer.cancel()
+
+    def _round(self, round_id: int) -> _Round:
+        """ State of the round, which starts the first time it is needed. """
+        current = self._rounds.get(round_id)
+        if current is None:
+            seed = secrets.token_hex(SEED_SIZE) if self._commit_reveal else None
+            current = self._rounds[round_id] = _Round(seed)
+        return current
+
+    def _expected(self, agency: int) -> bool:
+        return self._roster is None or agency in self._roster
+
+    def _all_finished(self, current: _Round) -> bool:
+        if self._roster is not None:
+            return self._roster <= current.finished
+        return len(current.finished) >= self._total_agencies
+
+    def _deadline_expired(self, round_id: int) -> None:
+        with self._lock:
+            current = self._rounds[round_id]
+            if current.participants is not None:
+                return
+            subscribers = self._draw(current)
+        self._notify(subscribers)
+
+    def _draw(self, current: _Round) -> list:
+        """
+        Takes the agencies that finished sending their bets as the ones
+        taking part in the draw and returns the subscribers to tell
+        """
+        current.participants = set(current.finished)
+        if current.timer is not None:
+            current.timer.cancel()
+        current.winners = {agency: documents for agency, documents in current.winners.items()
+                           if agency in current.participants}
+        if current.seed is None:
+            logging.info(f'action: sorteo | result: success | agencias: {len(current.participants)}')
+        else:
+            logging.info(f'action: sorteo | result: success | agencias: {len(current.participants)} | '
+                         f'numero: {winning_number(current.seed)}')
+
+        subscribers = list(current.subscribers)
+        current.subscribers.clear()
+        return subscribers
@@ -0,0 +1,362 @@
+import datetime
+import socket
+import struct
+
+
+""" Bytes of the frame header holding the payload length. """
+HEADER_SIZE = 4
+""" Maximum size of a whole frame, header included. """
+MAX_FRAME_SIZE = 8 * 1024
+""" Maximum size of the payload carried by a frame. """
+MAX_PAYLOAD_SIZE = MAX_FRAME_SIZE - HEADER_SIZE
+
+""" Separates the fields of a message. """
+DELIMITER = '|'
+""" Separates the bets inside a BetBatchMessage. """
+RECORD_DELIMITER = ';'
+""" Precedes delimiters and itself when they appear inside a field. """
+ESCAPE = '\\'
+
+""" Status of the acks: every bet stored, invalid batch, server busy. """
+ACK_OK = 0
+ACK_REJECTED = 1
+ACK_BUSY = 2
+
+""" Round of the messages that do not pick one, which is left out of them. """
+DEFAULT_ROUND = 0
+
+""" Status of the registrations: registered, not in the roster, draw taken without it. """
+REGISTER_OK = 0
+REGISTER_UNKNOWN = 1
+REGISTER_CLOSED = 2
+
+
+class ProtocolError(Exception):
+    """ Raised when a frame or a message does not follow the protocol. """
+
+
+""" Framing: every message travels as [LEN_BYTES][PAYLOAD]. """
+
+def read_frame(sock: socket.socket):
+    """
+    Reads a whole frame avoiding short-reads and returns its payload.
+    None is returned when the peer closed the connection between frames
+    """
+    header = _recv_exactly(sock, HEADER_SIZE, allow_eof=True)
+    if header is None:
+        return None
+    size = struct.unpack('>I', header)[0]
+    if size > MAX_PAYLOAD_SIZE:
+        raise ProtocolError(f"frame announces {size} bytes")
+    return _recv_exactly(sock, size)
+
+
+def write_frame(sock: socket.socket, payload: bytes) -> None:
+    """ Writes the payload preceded by its length avoiding short-writes. """
+    if len(payload) > MAX_PAYLOAD_SIZE:
+        raise ProtocolError(f"payload of {len(payload)} bytes")
+    sock.sendall(struct.pack('>I', len(payload)) + payload)
+
+
+def _recv_exactly(sock: socket.socket, size: int, allow_eof: bool = False):
+    chunks = []
+    received = 0
+    while received < size:
+        chunk = sock.recv(size - received)
+        if not chunk:
+            if allow_eof and received == 0:
+                return None
+            raise ConnectionError("connection closed in the middle of a frame")
+        chunks.append(chunk)
+        received += len(chunk)
+    return b''.join(chunks)
+
+
+""" Codec: [MESSAGE_TYPE][DELIMITER][FIELD_1][DELIMITER][FIELD_2]... """
+
+class BetBatchMessage:
+    def __init__(self, batch_id: int, bets: list, round_id: int = DEFAULT_ROUND):
+        self.batch_id = batch_id
+        self.bets = bets
+        self.round = round_id
+
+
+class EndOfBetsMessage:
+    def __init__(self, agency: str, round_id: int = DEFAULT_ROUND):
+        self.agency = agency
+        self.round = round_id
+
+
+class WinnersRequestMessage:
+    def __init__(self, agency: str, round_id: int = DEFAULT_ROUND):
+        self.agency = agency
+        self.round = round_id
+
+
+class RegisterMessage:
+    def __init__(self, agency: str, round_id: int = DEFAULT_ROUND):
+        self.agency = agency
+        self.round = round_id
+
+
+class RevealRequestMessage:
+    def __init__(self, round_id: int = DEFAULT_ROUND):
+        self.round = round_id
+
+
+class PingMessage:
+    pass
+
+
+class PongMessage:
+    pass
+
+
+class InvalidBatch(ProtocolError):
+    """ Raised when the bets of a batch are not valid. Keeps the batch ID so it can be rejected. """
+
+    def __init__(self, batch_id: int, reason: str):
+        super().__init__(reason)
+        self.batch_id = batch_id
+
+
+def decode(payload: bytes):
+    """
+    Parses a message sent by an agency. Raises ProtocolError when it is
+    malformed or its type is unknown
+    """
+    try:
+        text = payload.decode('utf-8')
+    except UnicodeDecodeError:
+        raise ProtocolError("payload is not valid UTF-8")
+
+    msg_type, body = text, ''
+    for i, c in enumerate(text):
+        if c in (DELIMITER, RECORD_DELIMITER):
+            msg_type, body = text[:i], text[i:]
+            break
+
+    decoder = _DECODERS.get(msg_type)
+    if decoder is None:
+        raise ProtocolError(f"unknown message type {msg_type[:32]!r}")
+    return decoder(body)
+
+
+def encode(msg_type: str, *fields) -> bytes:
+    """ Serializes a message made of its type followed by its fields. """
+    parts = [msg_type]
+    for field in fields:
+        parts.append(DELIMITER)
+        parts.append(_escape(str(field)))
+    return ''.join(parts).encode('utf-8')
+
+
+def ack(batch_id: int, status: int) -> bytes:
+    return encode('AckMessage', batch_id, status)
+
+
+def ping() -> bytes:
+    return encode('PingMessage')
+
+
+def pong() -> bytes:
+    return encode('PongMessage')
+
+
+def registered(status: int, commitment: str = None) -> bytes:
+    """ The commitment to the draw of the round is only sent along with REGISTER_OK. """
+    if commitment is None or status != REGISTER_OK:
+        return encode('RegisteredMessage', status)
+    return encode('RegisteredMessage', status, commitment)
+
+
+def reveal(number: int, seed: str) -> bytes:
+    return encode('RevealMessage', number, seed)
+
+
+def draw_missed() -> bytes:
+    return encode('DrawMissedMessage')
+
+
+def draw_complete() -> bytes:
+    return encode('DrawCompleteMessage')
+
+
+def unknown_round() -> bytes:
+    return encode('UnknownRoundMessage')
+
+
+def winners_pending() -> bytes:
+    return encode('WinnersPendingMessage')
+
+
+def winners_notification(documents: list) -> bytes:
+    return encode('WinnersNotificationMessage', len(documents), *documents)
+
+
+def _decode_bet_batch(body: str) -> BetBatchMessage:
+    records = _split(body, RECORD_DELIMITER)
+    fields = _read_fields(records[0])
+    if len(fields) not in (1, 2):
+        raise ProtocolError(f"expected a batch id and its round, got {len(fields)} fields")
+    batch_id = _parse_id(fields[0])
+    round_id = _parse_round(fields[1:])
+
+    bets = []
+    for record in records[1:]:
+        try:
+            msg_type, bet_fields = _split_type(record)
+            if msg_type != 'BetMessage' or len(bet_fields) != 6:
+                raise ProtocolError("batch holds something other than a bet")
+            bets.append(_validate_bet(bet_fields))
+        except ProtocolError as e:
+            raise InvalidBatch(batch_id, str(e))
+    return BetBatchMessage(batch_id, bets, round_id)
+
+
+def _decode_end_of_bets(body: str) -> EndOfBetsMessage:
+    return EndOfBetsMessage(*_read_agency(body))
+
+
+def _decode_winners_request(body: str) -> WinnersRequestMessage:
+    return WinnersRequestMessage(*_read_agency(body))
+
+
+def _decode_register(body: str) -> RegisterMessage:
+    return RegisterMessage(*_read_agency(body))
+
+
+def _decode_reveal_request(body: str) -> RevealRequestMessage:
+    fields = _read_fields(body)
+    if len(fields) > 1:
+        raise ProtocolError(f"expected an optional round, got {len(fields)} fields")
+    return RevealRequestMessage(_parse_round(fields))
+
+
+def _decode_ping(body: str) -> PingMessage:
+    _read_empty(body)
+    return PingMessage()
+
+
+def _decode_pong(body: str) -> PongMessage:
+    _read_empty(body)
+    return PongMessage()
+
+
+_DECODERS = {
+    'RegisterMessage': _decode_register,
+    'PingMessage': _decode_ping,
+    'PongMessage': _decode_pong,
+    'BetBatchMessage': _decode_bet_batch,
+    'EndOfBetsMessage': _decode_end_of_bets,
+    'WinnersRequestMessage': _decode_winners_request,
+    'RevealRequestMessage': _decode_reveal_request,
+}
+
+
+def _split_type(record: str):
+    for i, c in enumerate(record):
+        if c == DELIMITER:
+            return record[:i], _read_fields(record[i:])
+    return record, []
+
+
+def _validate_bet(fields: list) -> list:
+    """ Checks the bet fields with the rules of the client codec. """
+    agency, first_name, last_name, document, birthdate, number = fields
+    _validate_numeric('agency', agency, 9)
+    for name in (first_name, last_name):
+        if not name or len(name.encode('utf-8')) > 255:
+            raise ProtocolError(f"invalid name {name[:32]!r}")
+    _validate_numeric('document', document, 10)
+    _validate_numeric('number', number, 9)
+    try:
+        if len(birthdate) != 10:
+            raise ValueError
+        datetime.date.fromisoformat(birthdate)
+    except ValueError:
+        raise ProtocolError(f"invalid birthdate {birthdate[:32]!r}")
+    return fields
+
+
+def _validate_numeric(field: str, value: str, max_length: int) -> None:
+    if not value or len(value) > max_length or not all('0' <= c <= '9' for c in value):
+        raise ProtocolError(f"invalid {field} {value[:32]!r}")
+
+
+def _read_agency(body: str) -> tuple:
+    """ Parses an agency optionally followed by a round. """
+    fields = _read_fields(body)
+    if len(fields) not in (1, 2):
+        raise ProtocolError(f"expected an agency and its round, got {len(fields)} fields")
+    _validate_numeric('agency', fields[0], 9)
+    return fields[0], _parse_round(fields[1:])
+
+
+def _parse_round(fields: list) -> int:
+    """ The default round is left out, so writing it is refused as it has no other encoding. """
+    if not fields:
+        return DEFAULT_ROUND
+    round_id = _parse_id(fields[0])
+    if round_id == DEFAULT_ROUND:
+        raise ProtocolError("the default round must be left out")
+    return round_id
+
+
+def _read_empty(body: str) -> None:
+    if body != '':
+        raise ProtocolError("message does not hold fields")
+
+
+def _parse_id(value: str) -> int:
+    if (not value or not all('0' <= c <= '9' for c in value)
+            or str(int(value)) != value or int(value) >= 1 << 64):
+        raise ProtocolError(f"{value[:32]!r} is not a valid id")
+    return int(value)
+
+
+def _read_fields(body: str) -> list:
+    if body == '':
+        return []
+    if body[0] != DELIMITER:
+        raise ProtocolError("fields must be preceded by the delimiter")
+    return [_unescape(field) for field in _split(body[1:], DELIMITER)]
+
+
+def _split(s: str, sep: str) -> list:
+    """ Slices s around every sep that is not escaped, keeping escape sequences. """
+    parts = []
+    start = 0
+    i = 0
+    while i < len(s):
+        if s[i] == ESCAPE:
+            i += 1
+        elif s[i] == sep:
+            parts.append(s[start:i])
+            start = i + 1
+        i += 1
+    parts.append(s[start:])
+    return parts
+
+
+def _escape(value: str) -> str:
+    special = (DELIMITER, RECORD_DELIMITER, ESCAPE)
+    return ''.join(ESCAPE + c if c in special else c for c in value)
+
+
+def _unescape(value: str) -> str:
+    special = (DELIMITER, RECORD_DELIMITER, ESCAPE)
+    result = []
+    i = 0
+    while i < len(value):
+        c = value[i]
+        if c == ESCAPE:
+            if i + 1 == len(value) or value[i + 1] not in special:
+                raise ProtocolError("invalid escape sequence")
+            i += 1
+            c = value[i]
+        elif c in special:
+            raise ProtocolError(f"unescaped delimiter {c!r}")
+        result.append(c)
+        i += 1
+    return ''.join(result)
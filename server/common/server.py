@@ -1,48 +1,172 @@
 import socket
 import logging
+import threading
+
+from common import protocol
+from common.lottery import DrawMissed, DrawNotCommitted, Lottery, UnknownRound
+
+
+class Connection:
+    """
+    Connection with an agency. Its frames may be written by the thread
+    attending it and by the thread that performs the draw at the same time
+    """
+
+    def __init__(self, sock):
+        self.sock = sock
+        self._lock = threading.Lock()
+
+    def send(self, payload):
+        with self._lock:
+            protocol.write_frame(self.sock, payload)
 
 
 class Server:
-    def __init__(self, port, listen_backlog):
+    def __init__(self, port, listen_backlog, total_agencies, roster=None, draw_deadline=0, max_pending=0,
+                 commit_reveal=False):
         # Initialize server socket
         self._server_socket = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+        self._server_socket.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
         self._server_socket.bind(('', port))
         self._server_socket.listen(listen_backlog)
+        self._lottery = Lottery(total_agencies, roster, draw_deadline, self.__push_draw, max_pending, commit_reveal)
+        self._running = True
+        self._clients_lock = threading.Lock()
+        self._clients = {}
 
     def run(self):
         """
-        Dummy Server loop
+        Server loop
 
-        Server that accept a new connections and establishes a
-        communication with a client. After client with communucation
-        finishes, servers starts to accept new connections again
+        Accepts new connections and serves each one of them in its own
+        thread, so every agency can send its bets at the same time. The
+        loop ends once stop is called, after the connections in progress
+        are closed
         """
+        while self._running:
+            try:
+                client_sock = self.__accept_new_connection()
+            except OSError:
+                break
+            thread = threading.Thread(target=self.__handle_client_connection, args=(client_sock,))
+            with self._clients_lock:
+                self._clients[client_sock] = thread
+            thread.start()
+
+        with self._clients_lock:
+            clients = list(self._clients.items())
+        for client_sock, thread in clients:
+            try:
+                client_sock.shutdown(socket.SHUT_RDWR)
+            except OSError:
+                pass
+            thread.join()
+        logging.info('action: shutdown | result: success')
 
-        # TODO: Modify this program to handle signal to graceful shutdown
-        # the server
-        while True:
-            client_sock = self.__accept_new_connection()
-            self.__handle_client_connection(client_sock)
+    def stop(self):
+        """ Makes run return. Safe to call from a signal handler. """
+        logging.info('action: shutdown | result: in_progress')
+        self._running = False
+        self._lottery.close()
+        try:
+            # Wakes up an accept blocked in another thread
+            self._server_socket.shutdown(socket.SHUT_RDWR)
+        except OSError:
+            pass
+        self._server_socket.close()
 
     def __handle_client_connection(self, client_sock):
         """
-        Read message from a specific client socket and closes the socket
+        Reads the messages of a client until it closes the connection,
+        answering each one of them
 
         If a problem arises in the communication with the client, the
         client socket will also be closed
         """
+        conn = Connection(client_sock)
         try:
-            # TODO: Modify the receive to avoid short-reads
-            msg = client_sock.recv(1024).rstrip().decode('utf-8')
-            addr = client_sock.getpeername()
-            logging.info(f'action: receive_message | result: success | ip: {addr[0]} | msg: {msg}')
-            # TODO: Modify the send to avoid short-writes
-            client_sock.send("{}\n".format(msg).encode('utf-8'))
-        except OSError as e:
-            logging.error("action: receive_message | result: fail | error: {e}")
+            while True:
+                payload = protocol.read_frame(client_sock)
+                if payload is None:
+                    break
+                reply = self.__handle_message(conn, payload)
+                if reply is not None:
+                    conn.send(reply)
+        except (OSError, protocol.ProtocolError) as e:
+            logging.error(f'action: receive_message | result: fail | error: {e}')
         finally:
+            self._lottery.unsubscribe(conn)
+            with self._clients_lock:
+                self._clients.pop(client_sock, None)
             client_sock.close()
 
+    def __handle_message(self, conn, payload):
+        """ Processes a message and returns the reply to send, if any. """
+        try:
+            msg = protocol.decode(payload)
+        except protocol.InvalidBatch as e:
+            logging.error(f'action: apuesta_recibida | result: fail | batch_id: {e.batch_id} | error: {e}')
+            return protocol.ack(e.batch_id, protocol.ACK_REJECTED)
+        except protocol.ProtocolError as e:
+            logging.error(f'action: receive_message | result: fail | error: {e}')
+            return protocol.ack(0, protocol.ACK_REJECTED)
+
+        if isinstance(msg, protocol.PingMessage):
+            return protocol.pong()
+        if isinstance(msg, protocol.RegisterMessage):
+            status = self._lottery.register(msg.agency, msg.round)
+            logging.info(f'action: register | result: success | agency: {msg.agency} | round: {msg.round} | status: {status}')
+            if status != protocol.REGISTER_OK:
+                return protocol.registered(status)
+            return protocol.registered(status, self._lottery.commitment(msg.round))
+        if isinstance(msg, protocol.BetBatchMessage):
+            status = self._lottery.store(msg.batch_id, msg.bets, msg.round)
+            if status == protocol.ACK_OK:
+                logging.info(f'action: apuesta_recibida | result: success | cantidad: {len(msg.bets)}')
+            elif status == protocol.ACK_BUSY:
+                logging.warning(f'action: apuesta_recibida | result: in_progress | batch_id: {msg.batch_id} | error: busy')
+            else:
+                logging.error(f'action: apuesta_recibida | result: fail | cantidad: {len(msg.bets)}')
+            return protocol.ack(msg.batch_id, status)
+        if isinstance(msg, protocol.EndOfBetsMessage):
+            try:
+                self._lottery.finish(msg.agency, conn, msg.round)
+            except DrawMissed:
+                return protocol.draw_missed()
+            return None
+        if isinstance(msg, protocol.WinnersRequestMessage):
+            try:
+                winners = self._lottery.winners(msg.agency, msg.round)
+            except DrawMissed:
+                return protocol.draw_missed()
+            except UnknownRound:
+                return protocol.unknown_round()
+            if winners is None:
+                return protocol.winners_pending()
+            return protocol.winners_notification(winners)
+        if isinstance(msg, protocol.RevealRequestMessage):
+            try:
+                revealed = self._lottery.reveal(msg.round)
+            except DrawNotCommitted:
+                return protocol.ack(0, protocol.ACK_REJECTED)
+            except UnknownRound:
+                return protocol.unknown_round()
+            if revealed is None:
+                return protocol.winners_pending()
+            return protocol.reveal(*revealed)
+        return protocol.ack(0, protocol.ACK_REJECTED)
+
+    def __push_draw(self, subscribers):
+        """
+        Tells the agencies waiting on their connection that the draw took
+        place. An agency that cannot be reached will ask for the winners
+        """
+        for subscriber in subscribers:
+            try:
+                subscriber.send(protocol.draw_complete())
+            except OSError as e:
+                logging.error(f'action: notificar_sorteo | result: fail | error: {e}')
+
     def __accept_new_connection(self):
         """
         Accept new connections
@@ -4,6 +4,7 @@ from configparser import ConfigParser
 from common.server import Server
 import logging
 import os
+import signal
 
 
 def initialize_config():
@@ -25,6 +26,15 @@ def initialize_config():
     try:
         config_params["port"] = int(os.getenv('SERVER_PORT', config["DEFAULT"]["SERVER_PORT"]))
         config_params["listen_backlog"] = int(os.getenv('SERVER_LISTEN_BACKLOG', config["DEFAULT"]["SERVER_LISTEN_BACKLOG"]))
+        config_params["total_agencies"] = int(os.getenv('TOTAL_AGENCIES', config["DEFAULT"]["TOTAL_AGENCIES"]))
+        roster = os.getenv('AGENCY_ROSTER', config["DEFAULT"]["AGENCY_ROSTER"])
+        config_params["roster"] = [int(agency) for agency in roster.split(',') if agency.strip()]
+        config_params["draw_deadline"] = float(os.getenv('DRAW_DEADLINE', config["DEFAULT"]["DRAW_DEADLINE"]))
+        config_params["max_pending"] = int(os.getenv('MAX_PENDING_BATCHES', config["DEFAULT"]["MAX_PENDING_BATCHES"]))
+        commit_reveal = os.getenv('COMMIT_REVEAL', config["DEFAULT"]["COMMIT_REVEAL"]).lower()
+        if commit_reveal not in ConfigParser.BOOLEAN_STATES:
+            raise ValueError(f"COMMIT_REVEAL {commit_reveal!r} is not a boolean")
+        config_params["commit_reveal"] = ConfigParser.BOOLEAN_STATES[commit_reveal]
         config_params["logging_level"] = os.getenv('LOGGING_LEVEL', config["DEFAULT"]["LOGGING_LEVEL"])
     except KeyError as e:
         raise KeyError("Key was not found. Error: {} .Aborting server".format(e))
@@ -39,16 +49,25 @@ def main():
     logging_level = config_params["logging_level"]
     port = config_params["port"]
     listen_backlog = config_params["listen_backlog"]
+    total_agencies = config_params["total_agencies"]
+    roster = config_params["roster"]
+    draw_deadline = config_params["draw_deadline"]
+    max_pending = config_params["max_pending"]
+    commit_reveal = config_params["commit_reveal"]
 
     initialize_log(logging_level)
 
     # Log config parameters at the beginning of the program to verify the configuration
     # of the component
     logging.debug(f"action: config | result: success | port: {port} | "
-                  f"listen_backlog: {listen_backlog} | logging_level: {logging_level}")
+                  f"listen_backlog: {listen_backlog} | total_agencies: {total_agencies} | "
+                  f"agency_roster: {roster} | draw_deadline: {draw_deadline} | "
+                  f"max_pending_batches: {max_pending} | commit_reveal: {commit_reveal} | "
+                  f"logging_level: {logging_level}")
 
     # Initialize server and start server loop
-    server = Server(port, listen_backlog)
+    server = Server(port, listen_backlog, total_agencies, roster, draw_deadline, max_pending, commit_reveal)
+    signal.signal(signal.SIGTERM, lambda signum, frame: server.stop())
     server.run()
 
 def initialize_log(logging_level):
@@ -0,0 +1,33 @@
+#!/usr/bin/env python3
+
+from common import protocol
+from main import initialize_config
+import socket
+import sys
+
+
+""" Time to wait for the server to answer before considering it unhealthy. """
+PING_TIMEOUT = 3
+
+
+def main():
+    """
+    Health check of the server
+
+    Sends a PingMessage to the local server and exits successfully only
+    when it answers with a PongMessage, which means it is ready to accept
+    the bets of the agencies
+    """
+    port = initialize_config()["port"]
+    try:
+        with socket.create_connection(('127.0.0.1', port), timeout=PING_TIMEOUT) as sock:
+            protocol.write_frame(sock, protocol.ping())
+            reply = protocol.read_frame(sock)
+            healthy = reply is not None and isinstance(protocol.decode(reply), protocol.PongMessage)
+    except (OSError, protocol.ProtocolError):
+        healthy = False
+    sys.exit(0 if healthy else 1)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,67 @@
+from common import protocol
+import socket
+import unittest
+
+
+BET = 'BetMessage|1|Santiago Lionel|Lorca|30904465|1999-03-17|7574'
+
+
+class TestProtocol(unittest.TestCase):
+
+    def test_frames_survive_a_round_trip(self):
+        a, b = socket.socketpair()
+        with a, b:
+            protocol.write_frame(a, b'PingMessage')
+            protocol.write_frame(a, b'')
+            self.assertEqual(b'PingMessage', protocol.read_frame(b))
+            self.assertEqual(b'', protocol.read_frame(b))
+            a.close()
+            self.assertIsNone(protocol.read_frame(b))
+
+    def test_oversized_frames_are_refused(self):
+        a, b = socket.socketpair()
+        with a, b:
+            a.sendall((protocol.MAX_PAYLOAD_SIZE + 1).to_bytes(4, 'big'))
+            with self.assertRaises(protocol.ProtocolError):
+                protocol.read_frame(b)
+
+    def test_decode_batch_keeps_escaped_fields(self):
+        bet = 'BetMessage|1|Ana\\|Mar\\;ia|Lorca|30904465|1999-03-17|7574'
+        msg = protocol.decode(f'BetBatchMessage|12;{bet};{BET}'.encode())
+        self.assertIsInstance(msg, protocol.BetBatchMessage)
+        self.assertEqual(12, msg.batch_id)
+        self.assertEqual(['1', 'Ana|Mar;ia', 'Lorca', '30904465', '1999-03-17', '7574'], msg.bets[0])
+        self.assertEqual(2, len(msg.bets))
+
+    def test_invalid_bets_reject_their_batch(self):
+        for bet in ('BetMessage|1|Ana|Lorca|30904465|1999-02-30|7574',
+                    'BetMessage|1||Lorca|30904465|1999-03-17|7574',
+                    'BetMessage|1|Ana|Lorca|3090446x|1999-03-17|7574',
+                    'BetMessage|1|Ana|Lorca|30904465|1999-03-17',
+                    'EndOfBetsMessage|1'):
+            with self.subTest(bet=bet):
+                with self.assertRaises(protocol.InvalidBatch) as ctx:
+                    protocol.decode(f'BetBatchMessage|7;{bet}'.encode())
+                self.assertEqual(7, ctx.exception.batch_id)
+
+    def test_decode_reads_the_optional_round(self):
+        self.assertEqual(0, protocol.decode(b'WinnersRequestMessage|1').round)
+        msg = protocol.decode(b'EndOfBetsMessage|1|3')
+        self.assertEqual(('1', 3), (msg.agency, msg.round))
+        self.assertEqual(3, protocol.decode(f'BetBatchMessage|7|3;{BET}'.encode()).round)
+        self.assertEqual(0, protocol.decode(b'RevealRequestMessage').round)
+        self.assertEqual(3, protocol.decode(b'RevealRequestMessage|3').round)
+
+    def test_malformed_messages_are_refused(self):
+        for payload in (b'', b'Hello', b'BetBatchMessage|07', b'BetBatchMessage|-1',
+                        b'EndOfBetsMessage', b'EndOfBetsMessage|1|2|3', b'WinnersRequestMessage|a',
+                        b'EndOfBetsMessage|1\\', b'\xff', b'EndOfBetsMessage|1|0',
+                        b'RegisterMessage|1|03', b'BetBatchMessage|1|0', b'RevealRequestMessage|0',
+                        b'RevealRequestMessage|1|2'):
+            with self.subTest(payload=payload):
+                with self.assertRaises(protocol.ProtocolError):
+                    protocol.decode(payload)
+
+    def test_encode_escapes_fields(self):
+        self.assertEqual(b'WinnersNotificationMessage|2|1\\|2|3\\\\', protocol.winners_notification(['1|2', '3\\']))
+        self.assertEqual(b'AckMessage|5|0', protocol.ack(5, protocol.ACK_OK))
@@ -0,0 +1,175 @@
+from common import protocol
+from common.server import Server
+from common.utils import STORAGE_FILEPATH, store_bets
+from unittest import mock
+import hashlib
+import os
+import socket
+import threading
+import unittest
+
+
+def bet(agency, document, number):
+    return f'BetMessage|{agency}|Santiago Lionel|Lorca|{document}|1999-03-17|{number}'
+
+
+class TestServer(unittest.TestCase):
+
+    def start(self, total_agencies=2, roster=None, draw_deadline=0, max_pending=0, commit_reveal=False):
+        server = Server(0, 5, total_agencies, roster, draw_deadline, max_pending, commit_reveal)
+        self.port = server._server_socket.getsockname()[1]
+        thread = threading.Thread(target=server.run)
+        thread.start()
+        self.addCleanup(thread.join)
+        self.addCleanup(server.stop)
+
+    def tearDown(self):
+        if os.path.exists(STORAGE_FILEPATH):
+            os.remove(STORAGE_FILEPATH)
+
+    def connect(self):
+        sock = socket.create_connection(('127.0.0.1', self.port))
+        self.addCleanup(sock.close)
+        return sock
+
+    def request(self, sock, payload):
+        protocol.write_frame(sock, payload.encode())
+        return protocol.read_frame(sock).decode()
+
+    def receive(self, sock):
+        return protocol.read_frame(sock).decode()
+
+    def test_draw_takes_place_once_every_agency_finished(self):
+        self.start()
+        first, second = self.connect(), self.connect()
+        batch = f'BetBatchMessage|1;{bet(1, 30904465, 7574)};{bet(1, 30904466, 1)}'
+        self.assertEqual('AckMessage|1|0', self.request(first, batch))
+        # A batch sent again after a lost ack is not stored twice
+        self.assertEqual('AckMessage|1|0', self.request(first, batch))
+        self.assertEqual('AckMessage|1|0', self.request(second, f'BetBatchMessage|1;{bet(2, 1, 7574)}'))
+
+        protocol.write_frame(first, b'EndOfBetsMessage|1')
+        self.assertEqual('WinnersPendingMessage', self.request(first, 'WinnersRequestMessage|1'))
+        protocol.write_frame(second, b'EndOfBetsMessage|2')
+
+        # Both agencies are told about the draw on the connection they
+        # notified the end of their bets through
+        self.assertEqual('DrawCompleteMessage', self.receive(first))
+        self.assertEqual('DrawCompleteMessage', self.receive(second))
+        self.assertEqual('WinnersNotificationMessage|1|30904465', self.request(first, 'WinnersRequestMessage|1'))
+        self.assertEqual('WinnersNotificationMessage|1|1', self.request(second, 'WinnersRequestMessage|2'))
+        # Agencies that did not finish before the draw took no part in it
+        self.assertEqual('DrawMissedMessage', self.request(second, 'WinnersRequestMessage|3'))
+
+        # An agency that notifies again after a reconnection is told right away
+        self.assertEqual('DrawCompleteMessage', self.request(self.connect(), 'EndOfBetsMessage|1'))
+
+    def test_ping_is_answered(self):
+        self.start()
+        sock = self.connect()
+        self.assertEqual('PongMessage', self.request(sock, 'PingMessage'))
+        self.assertEqual('AckMessage|0|1', self.request(sock, 'PingMessage|1'))
+
+    def test_invalid_messages_are_rejected(self):
+        self.start()
+        sock = self.connect()
+        self.assertEqual('AckMessage|4|1', self.request(sock, 'BetBatchMessage|4;BetMessage|1|a'))
+        self.assertEqual('AckMessage|0|1', self.request(sock, 'Hello'))
+        # The connection stays usable after a rejection
+        self.assertEqual('AckMessage|5|0', self.request(sock, f'BetBatchMessage|5;{bet(1, 1, 1)}'))
+
+    def test_draw_waits_for_the_roster(self):
+        self.start(roster=[1, 2])
+        first, second = self.connect(), self.connect()
+        self.assertEqual('RegisteredMessage|0', self.request(first, 'RegisterMessage|1'))
+        self.assertEqual('RegisteredMessage|1', self.request(first, 'RegisterMessage|3'))
+        self.assertEqual('AckMessage|1|1', self.request(first, f'BetBatchMessage|1;{bet(3, 1, 7574)}'))
+
+        protocol.write_frame(first, b'EndOfBetsMessage|1')
+        protocol.write_frame(first, b'EndOfBetsMessage|1')
+        self.assertEqual('WinnersPendingMessage', self.request(second, 'WinnersRequestMessage|1'))
+        protocol.write_frame(second, b'EndOfBetsMessage|2')
+        self.assertEqual('DrawCompleteMessage', self.receive(first))
+        self.assertEqual('DrawCompleteMessage', self.receive(second))
+
+    def test_draw_takes_place_at_the_deadline_without_late_agencies(self):
+        self.start(total_agencies=3, draw_deadline=0.2)
+        first, late = self.connect(), self.connect()
+        self.assertEqual('RegisteredMessage|0', self.request(first, 'RegisterMessage|1'))
+        self.assertEqual('AckMessage|1|0', self.request(first, f'BetBatchMessage|1;{bet(1, 1, 7574)}'))
+        self.assertEqual('AckMessage|1|0', self.request(late, f'BetBatchMessage|1;{bet(2, 2, 7574)}'))
+        protocol.write_frame(first, b'EndOfBetsMessage|1')
+
+        # Only the agency that finished before the deadline takes part
+        self.assertEqual('DrawCompleteMessage', self.receive(first))
+        self.assertEqual('WinnersNotificationMessage|1|1', self.request(first, 'WinnersRequestMessage|1'))
+        self.assertEqual('RegisteredMessage|2', self.request(late, 'RegisterMessage|2'))
+        self.assertEqual('AckMessage|2|1', self.request(late, f'BetBatchMessage|2;{bet(2, 3, 7574)}'))
+        self.assertEqual('DrawMissedMessage', self.request(late, 'EndOfBetsMessage|2'))
+        self.assertEqual('DrawMissedMessage', self.request(late, 'WinnersRequestMessage|2'))
+
+    def test_rounds_have_their_own_draw(self):
+        self.start(total_agencies=1)
+        sock = self.connect()
+        self.assertEqual('RegisteredMessage|0', self.request(sock, 'RegisterMessage|1|2'))
+        self.assertEqual('AckMessage|1|0', self.request(sock, f'BetBatchMessage|1|2;{bet(1, 1, 7574)}'))
+        self.assertEqual('AckMessage|1|0', self.request(sock, f'BetBatchMessage|1;{bet(1, 2, 7574)}'))
+        self.assertEqual('DrawCompleteMessage', self.request(sock, 'EndOfBetsMessage|1|2'))
+        self.assertEqual('WinnersNotificationMessage|1|1', self.request(sock, 'WinnersRequestMessage|1|2'))
+        self.assertEqual('WinnersPendingMessage', self.request(sock, 'WinnersRequestMessage|1'))
+        # Rounds nobody took part in are refused instead of left pending
+        self.assertEqual('UnknownRoundMessage', self.request(sock, 'WinnersRequestMessage|1|3'))
+
+    def test_batches_beyond_the_pending_limit_are_answered_busy(self):
+        self.start(max_pending=1)
+        storing, release = threading.Event(), threading.Event()
+
+        def slow_store_bets(bets):
+            storing.set()
+            release.wait()
+            store_bets(bets)
+
+        first, second = self.connect(), self.connect()
+        with mock.patch('common.lottery.store_bets', slow_store_bets):
+            protocol.write_frame(first, f'BetBatchMessage|1;{bet(1, 1, 7574)}'.encode())
+            self.assertTrue(storing.wait(5))
+            self.assertEqual('AckMessage|1|2', self.request(second, f'BetBatchMessage|1;{bet(2, 2, 7574)}'))
+            release.set()
+            self.assertEqual('AckMessage|1|0', self.receive(first))
+        # The busy batch is stored once it is sent again
+        self.assertEqual('AckMessage|1|0', self.request(second, f'BetBatchMessage|1;{bet(2, 2, 7574)}'))
+
+    def test_committed_draw_is_revealed_after_it_takes_place(self):
+        # Winning number and commitment the client draw package derives
+        # from this seed
+        seed = 'ab' * 32
+        number, commitment = 2808, hashlib.sha256(seed.encode()).hexdigest()
+        with mock.patch('common.lottery.secrets.token_hex', return_value=seed):
+            self.start(total_agencies=1, commit_reveal=True)
+            sock = self.connect()
+            self.assertEqual(f'RegisteredMessage|0|{commitment}', self.request(sock, 'RegisterMessage|1'))
+        self.assertEqual('WinnersPendingMessage', self.request(sock, 'RevealRequestMessage'))
+
+        # The fixed winning number of the draws without a commitment does not win
+        batch = f'BetBatchMessage|1;{bet(1, 1, number)};{bet(1, 2, 7574)}'
+        self.assertEqual('AckMessage|1|0', self.request(sock, batch))
+        self.assertEqual('DrawCompleteMessage', self.request(sock, 'EndOfBetsMessage|1'))
+        self.assertEqual(f'RevealMessage|{number}|{seed}', self.request(sock, 'RevealRequestMessage'))
+        self.assertEqual('WinnersNotificationMessage|1|1', self.request(sock, 'WinnersRequestMessage|1'))
+        self.assertEqual('UnknownRoundMessage', self.request(sock, 'RevealRequestMessage|2'))
+
+    def test_every_round_commits_to_its_own_seed(self):
+        self.start(commit_reveal=True)
+        sock = self.connect()
+        first = self.request(sock, 'RegisterMessage|1')
+        second = self.request(sock, 'RegisterMessage|1|2')
+        self.assertRegex(first, r'^RegisteredMessage\|0\|[0-9a-f]{64}$')
+        self.assertNotEqual(first, second)
+        # Registering again keeps the commitment of the round
+        self.assertEqual(first, self.request(sock, 'RegisterMessage|2'))
+
+    def test_reveal_is_refused_without_commitments(self):
+        self.start()
+        sock = self.connect()
+        self.assertEqual('RegisteredMessage|0', self.request(sock, 'RegisterMessage|1'))
+        self.assertEqual('AckMessage|0|1', self.request(sock, 'RevealRequestMessage'))